@@ -0,0 +1,70 @@
+// Command gogue-validate checks a directory of Gogue data files for problems, without having to run the game.
+//
+// Install it with:
+//
+//	go install github.com/gogue-framework/gogue/cmd/gogue-validate
+//
+// Usage:
+//
+//	gogue-validate [-components position,appearance,...] <data directory>
+//
+// Every data file (JSON, YAML, TOML) is checked to make sure it can be parsed, and every definition is checked for
+// template problems (inheriting from a template that does not exist, inheritance cycles, and templates defined more
+// than once). If a list of component names is given, every component used by a definition must be in the list, which
+// catches typos in component names. Fields can only be checked against the actual component types, so use
+// data.FileLoader.Validate from a games tests for that.
+// Every problem found is printed, and the command exits with a non-zero status if there were any.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/gogue-framework/gogue/data"
+	"github.com/gogue-framework/gogue/ecs"
+	"os"
+	"reflect"
+	"strings"
+)
+
+// anyComponent accepts any field values, so that definitions can be checked for component names only
+type anyComponent map[string]interface{}
+
+func (ac anyComponent) TypeOf() reflect.Type { return reflect.TypeOf(ac) }
+
+func main() {
+	components := flag.String("components", "", "comma separated list of valid component names")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %v [-components name,...] <data directory>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	fileLoader, err := data.NewFileLoader(flag.Arg(0))
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+
+	if *components == "" {
+		_, err = fileLoader.LoadTemplates()
+	} else {
+		controller := ecs.NewController()
+		for _, name := range strings.Split(*components, ",") {
+			controller.MapComponentClass(strings.TrimSpace(name), anyComponent{})
+		}
+
+		err = fileLoader.Validate(controller)
+	}
+
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	fmt.Println("No problems found")
+}
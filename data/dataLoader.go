@@ -1,9 +1,11 @@
 package data
 
 import (
-	"encoding/json"
+	"archive/zip"
+	"bytes"
+	"errors"
 	"fmt"
-	"io/ioutil"
+	"io/fs"
 	"os"
 	"path"
 	"path/filepath"
@@ -16,13 +18,28 @@ import (
 
 // FileLoader contains the location where data will be loaded from.. It also allows for loading data from a single file
 // or loading data from all files found in the source location.
+// The format of each file is determined by its extension. JSON, YAML, and TOML are supported, and other formats can be
+// added with RegisterFormat.
+// Data can be loaded from a directory on disk, any fs.FS (such as an embed.FS, so data can be bundled into the game
+// binary), or a zip archive. Further sources, such as mod directories, can be layered on top (see AddLayer). Files with
+// the same path in several layers are merged, with later layers replacing the definitions of earlier ones, key by key.
 type FileLoader struct {
 	dataFilesLocation string
+	formats           map[string]FormatDecoder
+	layers            []dataLayer
+}
+
+// dataLayer is a single source of data files. name is used to refer to the source in file names, and is the directory
+// for layers on disk, the archive for zip files, and empty for other file systems.
+type dataLayer struct {
+	name string
+	fsys fs.FS
 }
 
 // NewFileLoader creates a new FileLoader. If the location provided is invalid (doesn't exist), an error is returned
 func NewFileLoader(dataDir string) (*FileLoader, error) {
 	fileLoader := FileLoader{}
+	fileLoader.formats = defaultFormats()
 
 	// Check if the directory exists. If not, raise an error
 	if _, err := os.Stat(dataDir); err == nil {
@@ -31,65 +48,312 @@ func NewFileLoader(dataDir string) (*FileLoader, error) {
 		return nil, err
 	}
 
+	fileLoader.layers = []dataLayer{{name: dataDir, fsys: os.DirFS(dataDir)}}
+
 	return &fileLoader, nil
 }
 
-// LoadDataFromFile takes a single filename (located in DataLoader.dataFilesLocation), and parses it, returning a
-// map representation of the data contained in the file
-func (fl *FileLoader) LoadDataFromFile(fileName string) (map[string]interface{}, error) {
-	filePath := filepath.FromSlash(fl.dataFilesLocation + "/" + fileName)
-	jsonFile, err := os.Open(filePath)
+// NewFileLoaderFS creates a new FileLoader that loads data files from a file system, such as an embed.FS. Use fs.Sub to
+// load data from a directory within the file system.
+// Example:
+// //go:embed data
+// var dataFiles embed.FS
+// fileLoader := data.NewFileLoaderFS(dataFiles)
+func NewFileLoaderFS(fsys fs.FS) *FileLoader {
+	fileLoader := FileLoader{}
+	fileLoader.formats = defaultFormats()
+	fileLoader.layers = []dataLayer{{fsys: fsys}}
+
+	return &fileLoader
+}
 
+// NewFileLoaderZip creates a new FileLoader that loads data files from a zip archive. The archive is read into memory
+// straight away, so nothing needs to be closed afterwards.
+func NewFileLoaderZip(archive string) (*FileLoader, error) {
+	layer, err := zipLayer(archive)
 	if err != nil {
-		fmt.Println(err)
 		return nil, err
 	}
 
-	defer jsonFile.Close()
+	fileLoader := FileLoader{}
+	fileLoader.formats = defaultFormats()
+	fileLoader.dataFilesLocation = archive
+	fileLoader.layers = []dataLayer{layer}
+
+	return &fileLoader, nil
+}
+
+// AddLayer layers another file system on top of the FileLoaders data, such as a mod. Files with the same path (ignoring
+// their extension) as a file in an earlier layer are merged into it, by key: groups of definitions are merged, and
+// definitions in the new layer replace whole definitions with the same path. Files that only exist in the new layer are
+// loaded as they are. Layers are applied in the order they are added.
+func (fl *FileLoader) AddLayer(fsys fs.FS) {
+	fl.layers = append(fl.layers, dataLayer{fsys: fsys})
+}
+
+// AddDirectory layers a directory on disk on top of the FileLoaders data (see AddLayer). If the directory does not
+// exist, an error is returned.
+func (fl *FileLoader) AddDirectory(dataDir string) error {
+	info, err := os.Stat(dataDir)
+	if err != nil {
+		return err
+	}
+
+	if !info.IsDir() {
+		return fmt.Errorf("%v is not a directory", dataDir)
+	}
+
+	fl.layers = append(fl.layers, dataLayer{name: dataDir, fsys: os.DirFS(dataDir)})
+	return nil
+}
+
+// AddZip layers the contents of a zip archive on top of the FileLoaders data (see AddLayer)
+func (fl *FileLoader) AddZip(archive string) error {
+	layer, err := zipLayer(archive)
+	if err != nil {
+		return err
+	}
+
+	fl.layers = append(fl.layers, layer)
+	return nil
+}
+
+// zipLayer reads a zip archive into memory, and returns it as a dataLayer
+func zipLayer(archive string) (dataLayer, error) {
+	contents, err := os.ReadFile(archive)
+	if err != nil {
+		return dataLayer{}, err
+	}
+
+	reader, err := zip.NewReader(bytes.NewReader(contents), int64(len(contents)))
+	if err != nil {
+		return dataLayer{}, &DataError{File: archive, Err: err}
+	}
 
-	byteValue, _ := ioutil.ReadAll(jsonFile)
+	return dataLayer{name: archive, fsys: reader}, nil
+}
 
+// LoadDataFromFile takes a single filename (located in DataLoader.dataFilesLocation), and parses it, returning a
+// map representation of the data contained in the file. The file is parsed according to its extension. If there is no
+// FormatDecoder for the extension, the file cannot be read, or the file cannot be parsed, a DataError naming the file
+// is returned.
+// If the FileLoader has several layers, the file is loaded from every layer that has it, and merged (see AddLayer).
+// As with LoadAllFromFiles, files are matched across layers by their path without the extension, so a mod can replace
+// definitions from enemies.json with its own enemies.yaml.
+func (fl *FileLoader) LoadDataFromFile(fileName string) (map[string]interface{}, error) {
 	var result map[string]interface{}
+	var notFound error
+
+	if _, ok := fl.formatFor(fileName); !ok {
+		return nil, &DataError{File: fileName, Err: fmt.Errorf("no format has been registered for %v files", filepath.Ext(fileName))}
+	}
+
+	for i := range fl.layers {
+		layerFile, err := fl.findLayerFile(i, fileName)
+
+		// Layers only need to provide the files they change, so the file only has to exist in one of them
+		if errors.Is(err, fs.ErrNotExist) {
+			if notFound == nil {
+				notFound = err
+			}
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		layerData, err := fl.loadLayerFile(i, layerFile)
+		if err != nil {
+			return nil, err
+		}
+
+		if result == nil {
+			result = layerData
+		} else {
+			result = mergeDefinitions(result, layerData)
+		}
+	}
+
+	if result == nil {
+		if notFound == nil {
+			notFound = &DataError{File: fileName, Err: fs.ErrNotExist}
+		}
+		return nil, notFound
+	}
+
+	return result, nil
+}
+
+// findLayerFile returns the file in a layer with the same path as fileName, ignoring the extension. Only files with a
+// registered format are considered. If the layer has no such file, the error wraps fs.ErrNotExist, and if it has more
+// than one, it is not clear which should be loaded, so an error is returned.
+func (fl *FileLoader) findLayerFile(layerIndex int, fileName string) (string, error) {
+	// Clean the name, so "./enemies.json" matches enemies.json in every layer
+	slashName := path.Clean(filepath.ToSlash(fileName))
+	dir := path.Dir(slashName)
+	key := strings.TrimSuffix(slashName, path.Ext(slashName))
+
+	entries, err := fs.ReadDir(fl.layers[layerIndex].fsys, dir)
+	if err != nil {
+		return "", &DataError{File: fl.displayName(layerIndex, fileName), Err: err}
+	}
+
+	found := ""
+	for _, entry := range entries {
+		entryPath := path.Join(dir, entry.Name())
+		if entry.IsDir() || strings.TrimSuffix(entryPath, path.Ext(entryPath)) != key {
+			continue
+		}
+
+		if _, ok := fl.formatFor(entryPath); !ok {
+			continue
+		}
+
+		if found != "" {
+			return "", &DataError{File: fl.displayName(layerIndex, entryPath), Err: fmt.Errorf("%v has already been loaded from %v", key, found)}
+		}
+		found = entryPath
+	}
+
+	if found == "" {
+		return "", &DataError{File: fl.displayName(layerIndex, fileName), Err: fs.ErrNotExist}
+	}
+
+	return found, nil
+}
+
+// loadLayerFile loads a single file from a single layer
+func (fl *FileLoader) loadLayerFile(layerIndex int, fileName string) (map[string]interface{}, error) {
+	displayName := fl.displayName(layerIndex, fileName)
+
+	decoder, ok := fl.formatFor(fileName)
+	if !ok {
+		return nil, &DataError{File: displayName, Err: fmt.Errorf("no format has been registered for %v files", filepath.Ext(fileName))}
+	}
+
+	byteValue, err := fs.ReadFile(fl.layers[layerIndex].fsys, filepath.ToSlash(fileName))
+
+	if err != nil {
+		return nil, &DataError{File: displayName, Err: err}
+	}
+
+	result, err := decoder(byteValue)
 
-	json.Unmarshal([]byte(byteValue), &result)
+	if err != nil {
+		return nil, &DataError{File: displayName, Err: err}
+	}
 
 	return result, nil
 }
 
+// displayName returns the name used for a file in a layer in errors. Files in the first layer are named relative to the
+// data location, and files in any other layer are prefixed with the layers name, so it is clear which layer they came
+// from.
+func (fl *FileLoader) displayName(layerIndex int, fileName string) string {
+	if layerIndex == 0 || fl.layers[layerIndex].name == "" {
+		return fileName
+	}
+
+	return filepath.Join(fl.layers[layerIndex].name, fileName)
+}
+
 // LoadAllFromFiles will walk the data directory provided to the FileLoader, and load into dictionaries any data it
 // finds, and return these as a map, whose keys are the filenames, and the values the data loaded from those files.
-func (fl *FileLoader) LoadAllFromFiles() (map[string]map[string]interface{}, error) {
+// Only files with a registered format are loaded, so stray files (a README, or an editors swap files) are skipped. If
+// any extensions are provided (ie ".yaml"), only files with those extensions are loaded.
+// Keys are the path of the file without its extension, within the data directory the FileLoader was created with (ie
+// "data/enemies" for data/enemies.json), or within the file system, for FileLoaders created with NewFileLoaderFS. Files
+// from any further layers are merged into the files with the same key (see AddLayer).
+// A file that fails to load does not stop the rest from loading. Every failure is collected, and returned together as
+// an ErrorList, along with the data from the files that did load.
+func (fl *FileLoader) LoadAllFromFiles(extensions ...string) (map[string]map[string]interface{}, error) {
+	allowed := make(map[string]bool)
+	for _, extension := range extensions {
+		allowed[normalizeExtension(extension)] = true
+	}
+
 	data := make(map[string]map[string]interface{})
+	errs := ErrorList{}
 
-	var files []string
+	for i, layer := range fl.layers {
+		loaded := make(map[string]string)
 
-	err := filepath.Walk(fl.dataFilesLocation, func(path string, info os.FileInfo, err error) error {
-		files = append(files, path)
-		return nil
-	})
+		err := fs.WalkDir(layer.fsys, ".", func(filePath string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				errs = append(errs, &DataError{File: fl.displayName(i, filePath), Err: err})
+				return nil
+			}
 
-	if err != nil {
-		return nil, err
-	}
+			if entry.IsDir() {
+				return nil
+			}
 
-	baseDir := fl.dataFilesLocation + "/"
+			if _, ok := fl.formatFor(filePath); !ok {
+				return nil
+			}
 
-	for _, file := range files {
+			if len(allowed) > 0 && !allowed[normalizeExtension(path.Ext(filePath))] {
+				return nil
+			}
 
-		if file == fl.dataFilesLocation {
-			continue
-		}
+			layerData, err := fl.loadLayerFile(i, filePath)
+			if err != nil {
+				errs = append(errs, err)
+				return nil
+			}
+
+			fileName := fl.dataKey(filePath)
+			if other, ok := loaded[fileName]; ok {
+				errs = append(errs, &DataError{File: fl.displayName(i, filePath), Err: fmt.Errorf("%v has already been loaded from %v", fileName, other)})
+				return nil
+			}
+			loaded[fileName] = filePath
 
-		pathElements := strings.Split(file, baseDir)
-		loadedData, err := fl.LoadDataFromFile(strings.Join(pathElements, ""))
+			if existing, ok := data[fileName]; ok {
+				data[fileName] = mergeDefinitions(existing, layerData)
+			} else {
+				data[fileName] = layerData
+			}
+
+			return nil
+		})
 
 		if err != nil {
 			return nil, err
 		}
+	}
 
-		fileName := strings.TrimSuffix(file, path.Ext(file))
-		data[fileName] = loadedData
+	return data, errs.errorOrNil()
+}
+
+// dataKey returns the key a file is loaded under by LoadAllFromFiles: its path without the extension, within the data
+// location
+func (fl *FileLoader) dataKey(filePath string) string {
+	fileName := strings.TrimSuffix(filePath, path.Ext(filePath))
+	if fl.dataFilesLocation == "" {
+		return filepath.FromSlash(fileName)
+	}
+
+	return filepath.Join(fl.dataFilesLocation, filepath.FromSlash(fileName))
+}
+
+// mergeDefinitions merges the data from a file in a later layer on top of the data from an earlier one. Groups of
+// definitions are merged key by key, while definitions (maps with a "components" or "inherits" key), and any other
+// values, replace what was there before. Neither map is modified.
+func mergeDefinitions(base, override map[string]interface{}) map[string]interface{} {
+	merged := copyValue(base).(map[string]interface{})
+
+	for key, value := range override {
+		baseGroup, baseIsGroup := merged[key].(map[string]interface{})
+		overrideGroup, overrideIsGroup := value.(map[string]interface{})
+
+		if baseIsGroup && overrideIsGroup && !isDefinition(baseGroup) && !isDefinition(overrideGroup) {
+			merged[key] = mergeDefinitions(baseGroup, overrideGroup)
+		} else {
+			merged[key] = copyValue(value)
+		}
 	}
 
-	return data, nil
+	return merged
 }
@@ -1,11 +1,22 @@
 package data
 
 import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/gogue-framework/gogue/ecs"
+	"github.com/gogue-framework/gogue/gamemap"
+	"github.com/gogue-framework/gogue/randomnumbergenerator"
 	"github.com/gogue-framework/gogue/ui"
 	"github.com/stretchr/testify/assert"
+	"io/fs"
+	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
+	"testing/fstest"
+	"time"
 )
 
 func TestNewFileLoader(t *testing.T) {
@@ -130,3 +141,1005 @@ func TestEntityLoader_CreateSingleEntity(t *testing.T) {
 	assert.Equal(t, "b", appearance.Glyph.Char())
 	assert.Equal(t, "gray", appearance.Glyph.Color())
 }
+
+// templateSources parses inline JSON into the same shape returned by FileLoader.LoadAllFromFiles
+func templateSources(t *testing.T, files map[string]string) map[string]map[string]interface{} {
+	sources := make(map[string]map[string]interface{})
+
+	for fileName, contents := range files {
+		var data map[string]interface{}
+		assert.Nil(t, json.Unmarshal([]byte(contents), &data))
+		sources[fileName] = data
+	}
+
+	return sources
+}
+
+const baseTemplates = `{
+  "base": {
+    "creature": {
+      "components": {
+        "position": {},
+        "appearance": {"Description": "A creature.", "Layer": 1}
+      }
+    }
+  }
+}`
+
+const ratTemplates = `{
+  "level_1": {
+    "base_rat": {
+      "inherits": "base.creature",
+      "components": {
+        "appearance": {"Glyph": {"Char": "r", "Color": "brown"}}
+      }
+    },
+    "small_rat": {
+      "inherits": "base_rat",
+      "components": {
+        "appearance": {"Name": "Small rat"}
+      }
+    },
+    "large_rat": {
+      "inherits": "level_1.base_rat",
+      "components": {
+        "appearance": {"Name": "Large rat", "Glyph": {"Char": "R", "Color": "brown"}}
+      }
+    },
+    "ghost_rat": {
+      "inherits": ["small_rat"],
+      "components": {
+        "position": null
+      }
+    }
+  }
+}`
+
+func TestTemplateLibrary_Load(t *testing.T) {
+	library := NewTemplateLibrary()
+	err := library.Load(templateSources(t, map[string]string{"base": baseTemplates, "rats": ratTemplates}))
+
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"base.creature", "level_1.base_rat", "level_1.ghost_rat", "level_1.large_rat", "level_1.small_rat"}, library.Paths())
+
+	// Values are inherited over multiple levels, and overridden field by field
+	largeRat, ok := library.Template("level_1.large_rat")
+	assert.True(t, ok)
+	assert.Nil(t, largeRat[inheritsKey])
+
+	appearance := largeRat["components"].(map[string]interface{})["appearance"].(map[string]interface{})
+	assert.Equal(t, "Large rat", appearance["Name"])
+	assert.Equal(t, "A creature.", appearance["Description"])
+	assert.Equal(t, float64(1), appearance["Layer"])
+	assert.Equal(t, "R", appearance["Glyph"].(map[string]interface{})["Char"])
+
+	// A null value removes an inherited component
+	ghostRat, _ := library.Template("level_1.ghost_rat")
+	components := ghostRat["components"].(map[string]interface{})
+	assert.Nil(t, components["position"])
+	assert.Equal(t, "Small rat", components["appearance"].(map[string]interface{})["Name"])
+
+	// Templates handed out are copies
+	appearance["Name"] = "Changed"
+	largeRat, _ = library.Template("level_1.large_rat")
+	assert.Equal(t, "Large rat", largeRat["components"].(map[string]interface{})["appearance"].(map[string]interface{})["Name"])
+
+	_, ok = library.Template("level_1.does_not_exist")
+	assert.False(t, ok)
+}
+
+func TestTemplateLibrary_LoadErrors(t *testing.T) {
+	library := NewTemplateLibrary()
+
+	err := library.Load(templateSources(t, map[string]string{"rats": ratTemplates}))
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "base.creature")
+
+	err = library.Load(templateSources(t, map[string]string{"cycle": `{
+	  "a": {"inherits": "c", "components": {}},
+	  "b": {"inherits": "a", "components": {}},
+	  "c": {"inherits": "b", "components": {}}
+	}`}))
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "a -> c -> b -> a")
+
+	err = library.Load(templateSources(t, map[string]string{"base": baseTemplates, "base_copy": baseTemplates}))
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "base.creature")
+
+	err = library.Load(templateSources(t, map[string]string{"bad": `{"a": {"inherits": 1}}`}))
+	assert.NotNil(t, err)
+
+	// A failed load leaves the library as it was
+	assert.Nil(t, library.Load(templateSources(t, map[string]string{"base": baseTemplates})))
+	assert.NotNil(t, library.Load(templateSources(t, map[string]string{"rats": ratTemplates})))
+	assert.Equal(t, []string{"base.creature"}, library.Paths())
+}
+
+func TestEntityLoader_CreateFromTemplate(t *testing.T) {
+	controller := ecs.NewController()
+	controller.MapComponentClass("position", PositionComponent{})
+	controller.MapComponentClass("appearance", AppearanceComponent{})
+
+	entityLoader := NewEntityLoader(controller)
+
+	_, err := entityLoader.CreateFromTemplate("level_1.large_rat", nil)
+	assert.NotNil(t, err)
+
+	library := NewTemplateLibrary()
+	assert.Nil(t, library.Load(templateSources(t, map[string]string{"base": baseTemplates, "rats": ratTemplates})))
+	entityLoader.SetTemplateLibrary(library)
+
+	entity, err := entityLoader.CreateFromTemplate("level_1.large_rat", nil)
+	assert.Nil(t, err)
+	assert.True(t, controller.HasComponent(entity, PositionComponent{}.TypeOf()))
+
+	appearance := controller.GetComponent(entity, AppearanceComponent{}.TypeOf()).(AppearanceComponent)
+	assert.Equal(t, "Large rat", appearance.Name)
+	assert.Equal(t, "A creature.", appearance.Description)
+	assert.Equal(t, "R", appearance.Glyph.Char())
+
+	// Overrides are applied to the new entity only
+	entity, err = entityLoader.CreateFromTemplate("level_1.large_rat", map[string]interface{}{
+		"appearance": map[string]interface{}{"Name": "Ratticus"},
+	})
+	assert.Nil(t, err)
+
+	appearance = controller.GetComponent(entity, AppearanceComponent{}.TypeOf()).(AppearanceComponent)
+	assert.Equal(t, "Ratticus", appearance.Name)
+	assert.Equal(t, "R", appearance.Glyph.Char())
+
+	template, _ := library.Template("level_1.large_rat")
+	assert.Equal(t, "Large rat", template["components"].(map[string]interface{})["appearance"].(map[string]interface{})["Name"])
+
+	_, err = entityLoader.CreateFromTemplate("level_1.does_not_exist", nil)
+	assert.NotNil(t, err)
+}
+
+func TestEntityLoader_ObserversCanCreateFromTemplates(t *testing.T) {
+	controller := ecs.NewController()
+	controller.MapComponentClass("position", PositionComponent{})
+	controller.MapComponentClass("appearance", AppearanceComponent{})
+
+	entityLoader := NewEntityLoader(controller)
+	library := NewTemplateLibrary()
+	assert.Nil(t, library.Load(templateSources(t, map[string]string{"rats": `{"level_1": {
+	  "rat_king": {"components": {"appearance": {"Name": "Rat king"}}},
+	  "rat": {"components": {"position": {}}}
+	}}`})))
+	entityLoader.SetTemplateLibrary(library)
+
+	// Every time a rat king appears, or changes, a rat appears with it
+	spawnRat := func(entity int, component ecs.Component) {
+		_, err := entityLoader.CreateFromTemplate("level_1.rat", nil)
+		assert.Nil(t, err)
+	}
+	controller.OnAdd(AppearanceComponent{}.TypeOf(), spawnRat)
+	controller.OnUpdate(AppearanceComponent{}.TypeOf(), func(entity int, previous, current ecs.Component) {
+		spawnRat(entity, current)
+	})
+
+	_, err := entityLoader.CreateFromTemplate("level_1.rat_king", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(controller.GetEntitiesWithComponent(PositionComponent{}.TypeOf())))
+
+	assert.Nil(t, entityLoader.ReloadTemplates(templateSources(t, map[string]string{"rats": `{"level_1": {
+	  "rat_king": {"components": {"appearance": {"Name": "Rat emperor"}}},
+	  "rat": {"components": {"position": {}}}
+	}}`}), true))
+	assert.Equal(t, 2, len(controller.GetEntitiesWithComponent(PositionComponent{}.TypeOf())))
+}
+
+// writeDataFiles writes each of the given files into a new temporary directory, and returns the directory
+func writeDataFiles(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+
+	for fileName, contents := range files {
+		assert.Nil(t, os.WriteFile(filepath.Join(dir, fileName), []byte(contents), 0644))
+	}
+
+	return dir
+}
+
+func TestFileLoader_LoadErrors(t *testing.T) {
+	dir := writeDataFiles(t, map[string]string{
+		"good.json":      `{"level_1": {}}`,
+		"truncated.json": `{"level_1": {`,
+		"list.json":      `["not", "an", "object"]`,
+	})
+	fileLoader, _ := NewFileLoader(dir)
+
+	_, err := fileLoader.LoadDataFromFile("truncated.json")
+	var dataError *DataError
+	assert.True(t, errors.As(err, &dataError))
+	assert.Equal(t, "truncated.json", dataError.File)
+
+	// Every broken file is reported, and the good ones are still loaded
+	dataMap, err := fileLoader.LoadAllFromFiles()
+	errs, ok := err.(ErrorList)
+	assert.True(t, ok)
+	assert.Equal(t, 2, len(errs))
+	assert.Contains(t, err.Error(), "file list.json")
+	assert.Contains(t, err.Error(), "file truncated.json")
+	assert.Equal(t, 1, len(dataMap))
+	assert.NotNil(t, dataMap[filepath.Join(dir, "good")])
+}
+
+func TestEntityLoader_CreateEntityFromData(t *testing.T) {
+	controller := ecs.NewController()
+	controller.MapComponentClass("position", PositionComponent{})
+	controller.MapComponentClass("appearance", AppearanceComponent{})
+
+	entityLoader := NewEntityLoader(controller)
+
+	// JSON numbers can be set on int fields
+	entity, err := entityLoader.CreateEntityFromData(map[string]interface{}{
+		"components": map[string]interface{}{
+			"position":   map[string]interface{}{"X": float64(3), "Y": float64(4)},
+			"appearance": map[string]interface{}{"Name": "Rat", "Layer": float64(2)},
+		},
+	})
+	assert.Nil(t, err)
+
+	position := controller.GetComponent(entity, PositionComponent{}.TypeOf()).(PositionComponent)
+	assert.Equal(t, PositionComponent{X: 3, Y: 4}, position)
+	assert.Equal(t, 2, controller.GetComponent(entity, AppearanceComponent{}.TypeOf()).(AppearanceComponent).Layer)
+
+	// Bad data is reported, field by field, rather than panicking
+	badData := map[string]interface{}{
+		"components": map[string]interface{}{
+			"position":   map[string]interface{}{"X": "three", "Y": 4.5},
+			"appearance": map[string]interface{}{"Name": float64(1), "Glyph": "r"},
+			"health":     map[string]interface{}{},
+		},
+	}
+
+	entity, err = entityLoader.CreateEntityFromData(badData)
+	assert.Equal(t, -1, entity)
+
+	errs := err.(ErrorList)
+	assert.Equal(t, 5, len(errs))
+
+	fields := []string{}
+	for _, err := range errs {
+		dataError := err.(*DataError)
+		fields = append(fields, dataError.Component+"."+dataError.Field)
+	}
+	assert.Equal(t, []string{"appearance.Glyph", "appearance.Name", "health.", "position.X", "position.Y"}, fields)
+
+	_, err = entityLoader.CreateEntityFromData(map[string]interface{}{})
+	assert.NotNil(t, err)
+
+	// CreateSingleEntity still creates what it can
+	entity = entityLoader.CreateSingleEntity(badData)
+	assert.NotEqual(t, -1, entity)
+	assert.True(t, controller.HasComponent(entity, PositionComponent{}.TypeOf()))
+	assert.True(t, controller.HasComponent(entity, AppearanceComponent{}.TypeOf()))
+}
+
+func TestTemplateErrorsNameTheirSource(t *testing.T) {
+	library := NewTemplateLibrary()
+
+	err := library.Load(templateSources(t, map[string]string{
+		"rats": `{
+		  "level_1": {
+		    "rat": {"inherits": "missing", "components": {}},
+		    "big_rat": {"inherits": "rat", "components": {}},
+		    "bat": {"inherits": "also_missing", "components": {}}
+		  }
+		}`,
+	}))
+
+	// Each problem is only reported once, even though big_rat inherits from a broken template
+	errs := err.(ErrorList)
+	assert.Equal(t, 2, len(errs))
+	assert.Equal(t, "rats", errs[0].(*DataError).File)
+	assert.Equal(t, "level_1.bat", errs[0].(*DataError).Path)
+	assert.Equal(t, "level_1.rat", errs[1].(*DataError).Path)
+
+	controller := ecs.NewController()
+	controller.MapComponentClass("position", PositionComponent{})
+
+	assert.Nil(t, library.Load(templateSources(t, map[string]string{
+		"rats": `{"level_1": {"rat": {"components": {"position": {"X": "left"}}}}}`,
+	})))
+
+	entityLoader := NewEntityLoader(controller)
+	entityLoader.SetTemplateLibrary(library)
+
+	_, err = entityLoader.CreateFromTemplate("level_1.rat", nil)
+	assert.Equal(t, "file rats, definition level_1.rat, component position, field X: expected a number, got a string", err.Error())
+}
+
+// Components for decoder tests
+type Stats struct {
+	Strength int
+	Agility  uint8
+}
+
+type Dice struct {
+	Count int
+	Sides int
+}
+
+type CreatureComponent struct {
+	Name       string `gogue:"name"`
+	Title      string `json:"title,omitempty"`
+	Hidden     bool
+	Speed      float32
+	Stats      Stats
+	Boss       *Stats
+	Tags       []string
+	Resistance map[string]float64
+	Loot       map[int]string
+	Slots      [2]int
+	Extra      interface{}
+	Damage     Dice
+	Ignored    string `gogue:"-"`
+	secret     string
+}
+
+func (cc CreatureComponent) TypeOf() reflect.Type {
+	return reflect.TypeOf(cc)
+}
+
+func TestEntityLoader_DecodeAllFieldTypes(t *testing.T) {
+	controller := ecs.NewController()
+	controller.MapComponentClass("creature", CreatureComponent{})
+
+	entityLoader := NewEntityLoader(controller)
+
+	// Dice are written as "2d6" in data files
+	RegisterDecoderFor(entityLoader, func(value interface{}) (Dice, error) {
+		dice := Dice{}
+		text, ok := value.(string)
+		if !ok {
+			return dice, errors.New("expected a dice expression")
+		}
+
+		_, err := fmt.Sscanf(text, "%dd%d", &dice.Count, &dice.Sides)
+		return dice, err
+	})
+
+	var data map[string]interface{}
+	assert.Nil(t, json.Unmarshal([]byte(`{
+	  "components": {
+	    "creature": {
+	      "name": "Rat king",
+	      "title": "the Gnawer",
+	      "Hidden": true,
+	      "Speed": 1.5,
+	      "Stats": {"Strength": 12, "Agility": 200},
+	      "Boss": {"Strength": 20},
+	      "Tags": ["rat", "boss"],
+	      "Resistance": {"poison": 0.5},
+	      "Loot": {"1": "cheese", "10": "crown"},
+	      "Slots": [3],
+	      "Extra": {"anything": [1, "goes"]},
+	      "Damage": "2d6"
+	    }
+	  }
+	}`), &data))
+
+	entity, err := entityLoader.CreateEntityFromData(data)
+	assert.Nil(t, err)
+
+	creature := controller.GetComponent(entity, CreatureComponent{}.TypeOf()).(CreatureComponent)
+	assert.Equal(t, "Rat king", creature.Name)
+	assert.Equal(t, "the Gnawer", creature.Title)
+	assert.True(t, creature.Hidden)
+	assert.Equal(t, float32(1.5), creature.Speed)
+	assert.Equal(t, Stats{Strength: 12, Agility: 200}, creature.Stats)
+	assert.Equal(t, &Stats{Strength: 20}, creature.Boss)
+	assert.Equal(t, []string{"rat", "boss"}, creature.Tags)
+	assert.Equal(t, map[string]float64{"poison": 0.5}, creature.Resistance)
+	assert.Equal(t, map[int]string{1: "cheese", 10: "crown"}, creature.Loot)
+	assert.Equal(t, [2]int{3, 0}, creature.Slots)
+	assert.Equal(t, map[string]interface{}{"anything": []interface{}{float64(1), "goes"}}, creature.Extra)
+	assert.Equal(t, Dice{Count: 2, Sides: 6}, creature.Damage)
+}
+
+func TestEntityLoader_DecodeErrors(t *testing.T) {
+	controller := ecs.NewController()
+	controller.MapComponentClass("creature", CreatureComponent{})
+
+	entityLoader := NewEntityLoader(controller)
+
+	var data map[string]interface{}
+	assert.Nil(t, json.Unmarshal([]byte(`{
+	  "components": {
+	    "creature": {
+	      "Name": "Renamed fields must use their tag name",
+	      "Ignored": "skipped fields can not be set",
+	      "Stats": {"Strength": 1, "Agility": 300, "Luck": 3},
+	      "Tags": ["rat", 7],
+	      "Loot": {"first": "cheese"},
+	      "Slots": [1, 2, 3],
+	      "Damage": {"Count": 1, "Sides": -1.5}
+	    }
+	  }
+	}`), &data))
+
+	_, err := entityLoader.CreateEntityFromData(data)
+
+	fields := []string{}
+	for _, err := range err.(ErrorList) {
+		fields = append(fields, err.(*DataError).Field)
+	}
+	assert.Equal(t, []string{"Damage.Sides", "Ignored", "Loot.first", "Name", "Slots", "Stats.Agility", "Stats.Luck", "Tags[1]"}, fields)
+}
+
+func TestFileLoader_Validate(t *testing.T) {
+	controller := ecs.NewController()
+	controller.MapComponentClass("position", PositionComponent{})
+	controller.MapComponentClass("appearance", AppearanceComponent{})
+
+	// The bundled test data uses components that are not mapped here
+	fileLoader, _ := NewFileLoader("testdata")
+	err := fileLoader.Validate(controller)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "definition level_2.small_rat_2, component cowardly")
+
+	dir := writeDataFiles(t, map[string]string{
+		"base.json": `{"creature": {"components": {"position": {}, "apperance": {}}}}`,
+		"rats.json": `{
+		  "rat": {"inherits": "creature", "components": {"position": {"X": "1", "Z": 2}}},
+		  "bat": {"inherits": "missing", "components": {}}
+		}`,
+	})
+
+	fileLoader, _ = NewFileLoader(dir)
+
+	// Template problems are reported first, as definitions cannot be checked until their templates resolve
+	err = fileLoader.Validate(controller)
+	assert.Equal(t, 1, len(err.(ErrorList)))
+	assert.Contains(t, err.Error(), "definition bat: inherits from missing")
+
+	// Once the templates are fixed, each definition is checked against the components
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "rats.json"), []byte(`{
+	  "rat": {"inherits": "creature", "components": {"position": {"X": "1", "Z": 2}}}
+	}`), 0644))
+
+	err = fileLoader.Validate(controller)
+	problems := []string{}
+	for _, err := range err.(ErrorList) {
+		dataError := err.(*DataError)
+		problems = append(problems, dataError.Path+" "+dataError.Component+" "+dataError.Field)
+	}
+	assert.Equal(t, []string{"creature apperance ", "rat apperance ", "rat position X", "rat position Z"}, problems)
+
+	// No entities are created while validating
+	assert.Empty(t, controller.Query(ecs.NewQuery().With(PositionComponent{}.TypeOf())))
+}
+
+func TestFileLoader_Formats(t *testing.T) {
+	dir := writeDataFiles(t, map[string]string{
+		"rats.yaml": `
+level_1:
+  large_rat:
+    components:
+      appearance:
+        Name: Large rat
+        Layer: 1
+        Glyph: {Char: R, Color: brown}
+`,
+		"bats.toml": `
+[level_1.cave_bat.components.appearance]
+Name = "Cave bat"
+Layer = 2
+Glyph = { Char = "b", Color = "gray" }
+
+[[level_1.cave_bat.components.appearance.Notes]]
+Text = "squeaks"
+`,
+		"README.md":   "# Not data",
+		".rats.swp":   "binary junk",
+		"bosses.json": `{"level_1": {"rat_king": {"components": {"appearance": {"Name": "Rat king"}}}}}`,
+	})
+	fileLoader, _ := NewFileLoader(dir)
+
+	// YAML and TOML are converted to the same shape as JSON
+	rats, err := fileLoader.LoadDataFromFile("rats.yaml")
+	assert.Nil(t, err)
+	appearance := rats["level_1"].(map[string]interface{})["large_rat"].(map[string]interface{})["components"].(map[string]interface{})["appearance"].(map[string]interface{})
+	assert.Equal(t, float64(1), appearance["Layer"])
+	assert.Equal(t, map[string]interface{}{"Char": "R", "Color": "brown"}, appearance["Glyph"])
+
+	bats, err := fileLoader.LoadDataFromFile("bats.toml")
+	assert.Nil(t, err)
+	appearance = bats["level_1"].(map[string]interface{})["cave_bat"].(map[string]interface{})["components"].(map[string]interface{})["appearance"].(map[string]interface{})
+	assert.Equal(t, float64(2), appearance["Layer"])
+	assert.Equal(t, []interface{}{map[string]interface{}{"Text": "squeaks"}}, appearance["Notes"])
+
+	_, err = fileLoader.LoadDataFromFile("README.md")
+	assert.NotNil(t, err)
+
+	// Files without a registered format are skipped
+	dataMap, err := fileLoader.LoadAllFromFiles()
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(dataMap))
+
+	dataMap, err = fileLoader.LoadAllFromFiles("yaml", ".JSON")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(dataMap))
+	assert.Nil(t, dataMap[filepath.Join(dir, "bats")])
+
+	// Entities are created the same way, whatever the format
+	controller := ecs.NewController()
+	controller.MapComponentClass("appearance", AppearanceComponent{})
+
+	entityLoader := NewEntityLoader(controller)
+	entity, err := entityLoader.CreateEntityFromData(rats["level_1"].(map[string]interface{})["large_rat"].(map[string]interface{}))
+	assert.Nil(t, err)
+	assert.Equal(t, "R", controller.GetComponent(entity, AppearanceComponent{}.TypeOf()).(AppearanceComponent).Glyph.Char())
+
+	// Custom formats can be registered
+	fileLoader.RegisterFormat("md", func(contents []byte) (map[string]interface{}, error) {
+		return map[string]interface{}{"readme": string(contents)}, nil
+	})
+	readme, err := fileLoader.LoadDataFromFile("README.md")
+	assert.Nil(t, err)
+	assert.Equal(t, "# Not data", readme["readme"])
+}
+
+// touchDataFile rewrites a data file, and moves its modification time forward, so a Watcher sees the change even on
+// file systems with coarse timestamps
+func touchDataFile(t *testing.T, dir, fileName, contents string) {
+	filePath := filepath.Join(dir, fileName)
+	assert.Nil(t, os.WriteFile(filePath, []byte(contents), 0644))
+
+	future := time.Now().Add(time.Minute)
+	assert.Nil(t, os.Chtimes(filePath, future, future))
+}
+
+func TestWatcher_Poll(t *testing.T) {
+	dir := writeDataFiles(t, map[string]string{
+		"rats.json": `{"rat": {"components": {"appearance": {"Name": "Rat"}}}}`,
+	})
+	fileLoader, _ := NewFileLoader(dir)
+	watcher := fileLoader.NewWatcher()
+
+	events := []ReloadEvent{}
+	watcher.Subscribe(func(event ReloadEvent) {
+		events = append(events, event)
+	})
+
+	assert.False(t, watcher.Poll())
+
+	touchDataFile(t, dir, "rats.json", `{"rat": {"components": {"appearance": {"Name": "Big rat"}}}}`)
+	touchDataFile(t, dir, "notes.txt", "not a data file")
+	touchDataFile(t, dir, "bats.yaml", "bat: {components: {}}")
+
+	assert.True(t, watcher.Poll())
+	assert.False(t, watcher.Poll())
+	assert.Equal(t, 1, len(events))
+	assert.Equal(t, []string{filepath.Join(dir, "bats.yaml"), filepath.Join(dir, "rats.json")}, events[0].Changed)
+	assert.Nil(t, events[0].Err)
+	assert.Equal(t, 2, len(events[0].Data))
+
+	// Removed files are changes too, and broken files are reported to subscribers
+	assert.Nil(t, os.Remove(filepath.Join(dir, "bats.yaml")))
+	touchDataFile(t, dir, "rats.json", `{"rat": `)
+
+	assert.True(t, watcher.Poll())
+	assert.Equal(t, 2, len(events[1].Changed))
+	assert.NotNil(t, events[1].Err)
+}
+
+func TestWatcher_StartStop(t *testing.T) {
+	dir := writeDataFiles(t, map[string]string{"rats.json": `{}`})
+	fileLoader, _ := NewFileLoader(dir)
+	watcher := fileLoader.NewWatcher()
+
+	reloaded := make(chan ReloadEvent, 1)
+	watcher.Subscribe(func(event ReloadEvent) {
+		reloaded <- event
+	})
+
+	watcher.Start(time.Millisecond)
+	watcher.Start(time.Millisecond)
+	touchDataFile(t, dir, "rats.json", `{"rat": {"components": {}}}`)
+
+	select {
+	case event := <-reloaded:
+		assert.Equal(t, []string{filepath.Join(dir, "rats.json")}, event.Changed)
+	case <-time.After(5 * time.Second):
+		t.Fatal("watcher did not pick up the change")
+	}
+
+	watcher.Stop()
+	watcher.Stop()
+}
+
+func TestEntityLoader_WatchTemplates(t *testing.T) {
+	dir := writeDataFiles(t, map[string]string{
+		"rats.json": `{"level_1": {
+		  "base_rat": {"components": {"appearance": {"Name": "Rat", "Description": "A rat.", "Layer": 1}}},
+		  "rat": {"inherits": "base_rat", "components": {"position": {}}}
+		}}`,
+	})
+	fileLoader, _ := NewFileLoader(dir)
+
+	controller := ecs.NewController()
+	controller.MapComponentClass("position", PositionComponent{})
+	controller.MapComponentClass("appearance", AppearanceComponent{})
+
+	library, err := fileLoader.LoadTemplates()
+	assert.Nil(t, err)
+
+	entityLoader := NewEntityLoader(controller)
+	entityLoader.SetTemplateLibrary(library)
+
+	watcher := fileLoader.NewWatcher()
+	reloadErrors := []error{}
+	entityLoader.WatchTemplates(watcher, true, func(err error) {
+		reloadErrors = append(reloadErrors, err)
+	})
+
+	rat, _ := entityLoader.CreateFromTemplate("level_1.rat", nil)
+	namedRat, _ := entityLoader.CreateFromTemplate("level_1.rat", map[string]interface{}{
+		"appearance": map[string]interface{}{"Name": "Ratticus"},
+	})
+	deadRat, _ := entityLoader.CreateFromTemplate("level_1.rat", nil)
+	controller.DeleteEntity(deadRat)
+
+	// Deleted entities are forgotten straight away, so an entity reusing the ID is not mistaken for a rat
+	assert.Equal(t, 2, len(entityLoader.spawned))
+	notARat := controller.CreateEntity([]ecs.Component{AppearanceComponent{Name: "Not a rat"}})
+	assert.Equal(t, deadRat, notARat)
+
+	// Changes made while playing are kept when the template is reloaded
+	controller.AddComponent(rat, PositionComponent{X: 5, Y: 5})
+
+	touchDataFile(t, dir, "rats.json", `{"level_1": {
+	  "base_rat": {"components": {"appearance": {"Name": "Sewer rat", "Layer": 2}}},
+	  "rat": {"inherits": "base_rat", "components": {"position": {"X": 1}, "blocks": {}}}
+	}}`)
+
+	// The unmapped "blocks" component stops the rats being fully updated, but the templates are still reloaded
+	assert.True(t, watcher.Poll())
+	assert.Equal(t, 1, len(reloadErrors))
+	template, _ := library.Template("level_1.base_rat")
+	assert.Equal(t, "Sewer rat", template["components"].(map[string]interface{})["appearance"].(map[string]interface{})["Name"])
+
+	appearance := controller.GetComponent(rat, AppearanceComponent{}.TypeOf()).(AppearanceComponent)
+	assert.Equal(t, AppearanceComponent{Name: "Sewer rat", Layer: 2}, appearance)
+	assert.Equal(t, PositionComponent{X: 1, Y: 5}, controller.GetComponent(rat, PositionComponent{}.TypeOf()))
+
+	appearance = controller.GetComponent(namedRat, AppearanceComponent{}.TypeOf()).(AppearanceComponent)
+	assert.Equal(t, "Ratticus", appearance.Name)
+	assert.Equal(t, 2, appearance.Layer)
+
+	assert.Equal(t, AppearanceComponent{Name: "Not a rat"}, controller.GetComponent(notARat, AppearanceComponent{}.TypeOf()))
+
+	// Removing a component from the template removes it from spawned entities
+	touchDataFile(t, dir, "rats.json", `{"level_1": {
+	  "base_rat": {"components": {"appearance": {"Name": "Sewer rat", "Layer": 2}}},
+	  "rat": {"inherits": "base_rat", "components": {}}
+	}}`)
+
+	// The unmapped "blocks" component can not be removed either, so it is reported again
+	assert.True(t, watcher.Poll())
+	assert.Equal(t, 2, len(reloadErrors))
+	assert.False(t, controller.HasComponent(rat, PositionComponent{}.TypeOf()))
+	assert.True(t, controller.HasComponent(rat, AppearanceComponent{}.TypeOf()))
+
+	// Broken templates leave everything as it was
+	assert.NotNil(t, entityLoader.ReloadTemplates(map[string]map[string]interface{}{"rats": {"rat": map[string]interface{}{"inherits": "missing"}}}, true))
+	assert.Equal(t, []string{"level_1.base_rat", "level_1.rat"}, library.Paths())
+	assert.True(t, controller.HasComponent(rat, AppearanceComponent{}.TypeOf()))
+
+	// Files that cannot be loaded are reported too
+	touchDataFile(t, dir, "rats.json", `{"level_1": `)
+	assert.True(t, watcher.Poll())
+	assert.Equal(t, 3, len(reloadErrors))
+	assert.True(t, controller.HasComponent(rat, AppearanceComponent{}.TypeOf()))
+}
+
+func TestNewFileLoaderFS(t *testing.T) {
+	fileSystem := fstest.MapFS{
+		"monsters/rats.json": {Data: []byte(`{"level_1": {"rat": {"components": {"appearance": {"Name": "Rat"}}}}}`)},
+		"monsters/bats.yml":  {Data: []byte(`level_1: {bat: {components: {appearance: {Name: Bat}}}}`)},
+		"README.md":          {Data: []byte(`# Not data`)},
+	}
+
+	fileLoader := NewFileLoaderFS(fileSystem)
+
+	rats, err := fileLoader.LoadDataFromFile("monsters/rats.json")
+	assert.Nil(t, err)
+	assert.NotNil(t, rats["level_1"])
+
+	dataMap, err := fileLoader.LoadAllFromFiles()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(dataMap))
+	assert.NotNil(t, dataMap[filepath.Join("monsters", "rats")])
+	assert.NotNil(t, dataMap[filepath.Join("monsters", "bats")])
+
+	library, err := fileLoader.LoadTemplates()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"level_1.bat", "level_1.rat"}, library.Paths())
+}
+
+// writeZip writes the given files into a zip archive, and returns the path of the archive
+func writeZip(t *testing.T, files map[string]string) string {
+	archive := filepath.Join(t.TempDir(), "data.zip")
+	zipFile, err := os.Create(archive)
+	assert.Nil(t, err)
+
+	writer := zip.NewWriter(zipFile)
+	for fileName, contents := range files {
+		file, err := writer.Create(fileName)
+		assert.Nil(t, err)
+		_, err = file.Write([]byte(contents))
+		assert.Nil(t, err)
+	}
+
+	assert.Nil(t, writer.Close())
+	assert.Nil(t, zipFile.Close())
+
+	return archive
+}
+
+func TestFileLoaderLayers(t *testing.T) {
+	archive := writeZip(t, map[string]string{
+		"enemies.json": `{
+		  "level_1": {
+		    "rat": {"components": {"appearance": {"Name": "Rat", "Layer": 1}}},
+		    "bat": {"components": {"appearance": {"Name": "Bat"}}}
+		  },
+		  "level_2": {"snake": {"components": {"appearance": {"Name": "Snake"}}}}
+		}`,
+	})
+
+	fileLoader, err := NewFileLoaderZip(archive)
+	assert.Nil(t, err)
+
+	_, err = NewFileLoaderZip(filepath.Join(t.TempDir(), "missing.zip"))
+	assert.NotNil(t, err)
+
+	// A mod replaces the rat, and adds a new monster and a new file. The bat and the snake are untouched.
+	modDir := writeDataFiles(t, map[string]string{
+		"enemies.yaml": `
+level_1:
+  rat: {components: {appearance: {Name: Mod rat}}}
+  wolf: {components: {appearance: {Name: Wolf}}}
+`,
+		"items.json": `{"potion": {"components": {}}}`,
+	})
+	assert.Nil(t, fileLoader.AddDirectory(modDir))
+	assert.NotNil(t, fileLoader.AddDirectory(filepath.Join(modDir, "missing")))
+
+	// A second mod, added later, wins
+	fileLoader.AddLayer(fstest.MapFS{
+		"enemies.json": {Data: []byte(`{"level_1": {"wolf": {"components": {"appearance": {"Name": "Dire wolf"}}}}}`)},
+	})
+
+	dataMap, err := fileLoader.LoadAllFromFiles()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(dataMap))
+
+	enemies := dataMap[filepath.Join(archive, "enemies")]
+	levelOne := enemies["level_1"].(map[string]interface{})
+	assert.Equal(t, 3, len(levelOne))
+	assert.NotNil(t, enemies["level_2"])
+
+	// Definitions are replaced whole, not merged
+	ratAppearance := levelOne["rat"].(map[string]interface{})["components"].(map[string]interface{})["appearance"].(map[string]interface{})
+	assert.Equal(t, map[string]interface{}{"Name": "Mod rat"}, ratAppearance)
+
+	wolfAppearance := levelOne["wolf"].(map[string]interface{})["components"].(map[string]interface{})["appearance"].(map[string]interface{})
+	assert.Equal(t, "Dire wolf", wolfAppearance["Name"])
+
+	// Single files are merged across layers too
+	enemiesFile, err := fileLoader.LoadDataFromFile("enemies.json")
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(enemiesFile["level_1"].(map[string]interface{})))
+
+	// Files are matched by name, whatever their format, so the mods enemies.yaml replaces the rat from enemies.json
+	levelOne = enemiesFile["level_1"].(map[string]interface{})
+	ratAppearance = levelOne["rat"].(map[string]interface{})["components"].(map[string]interface{})["appearance"].(map[string]interface{})
+	assert.Equal(t, map[string]interface{}{"Name": "Mod rat"}, ratAppearance)
+
+	wolfAppearance = levelOne["wolf"].(map[string]interface{})["components"].(map[string]interface{})["appearance"].(map[string]interface{})
+	assert.Equal(t, "Dire wolf", wolfAppearance["Name"])
+
+	// The same file can be asked for by any of its names
+	for _, fileName := range []string{"enemies.yaml", "./enemies.json", "sub/../enemies.toml"} {
+		loaded, err := fileLoader.LoadDataFromFile(fileName)
+		assert.Nil(t, err)
+		assert.Equal(t, enemiesFile, loaded, fileName)
+	}
+
+	items, err := fileLoader.LoadDataFromFile("items.json")
+	assert.Nil(t, err)
+	assert.NotNil(t, items["potion"])
+
+	_, err = fileLoader.LoadDataFromFile("missing.json")
+	assert.True(t, errors.Is(err, fs.ErrNotExist))
+
+	// A layer with the same file in two formats is ambiguous
+	fileLoader.AddLayer(fstest.MapFS{
+		"items.json": {Data: []byte(`{}`)},
+		"items.toml": {Data: []byte(``)},
+	})
+	_, err = fileLoader.LoadDataFromFile("items.json")
+	assert.NotNil(t, err)
+	assert.False(t, errors.Is(err, fs.ErrNotExist))
+}
+
+const spawnTableData = `{
+  "spawns": {
+    "level_monsters": {
+      "rolls": "2d3",
+      "entries": [
+        {"template": "level_1.small_rat", "weight": 50, "count": "1d3"},
+        {"template": "level_1.large_rat", "weight": 30, "max_depth": 2},
+        {"table": "spawns.rare_monsters", "weight": 5, "min_depth": 3}
+      ]
+    },
+    "rare_monsters": {
+      "entries": [
+        {"template": "level_1.ghost_rat", "count": 2}
+      ]
+    }
+  }
+}`
+
+func TestSpawnTables_Draw(t *testing.T) {
+	spawnTables := NewSpawnTables()
+	assert.Nil(t, spawnTables.Load(templateSources(t, map[string]string{"rats": ratTemplates, "spawns": spawnTableData})))
+	assert.Equal(t, []string{"spawns.level_monsters", "spawns.rare_monsters"}, spawnTables.Paths())
+
+	table, ok := spawnTables.Table("spawns.level_monsters")
+	assert.True(t, ok)
+	assert.Equal(t, randomnumbergenerator.Dice{Count: 2, Sides: 3}, table.Rolls)
+	assert.Equal(t, 3, len(table.Entries))
+	assert.Equal(t, SpawnEntry{Template: "level_1.small_rat", Weight: 50, Count: randomnumbergenerator.Dice{Count: 1, Sides: 3}}, table.Entries[0])
+	assert.Equal(t, randomnumbergenerator.Dice{Modifier: 1}, table.Entries[1].Count)
+
+	library := NewTemplateLibrary()
+	assert.Nil(t, library.Load(templateSources(t, map[string]string{"base": baseTemplates, "rats": ratTemplates})))
+	assert.Nil(t, spawnTables.CheckTemplates(library))
+
+	// The same seed always draws the same templates
+	first, err := spawnTables.Draw("spawns.level_monsters", 1, randomnumbergenerator.NewSeededRNG(42))
+	assert.Nil(t, err)
+	second, _ := spawnTables.Draw("spawns.level_monsters", 1, randomnumbergenerator.NewSeededRNG(42))
+	assert.Equal(t, first, second)
+
+	// Depth limits which entries can be drawn, and nested tables are drawn from
+	rng := randomnumbergenerator.NewSeededRNG(7)
+	seen := make(map[int]map[string]bool)
+	for _, depth := range []int{1, 5} {
+		seen[depth] = make(map[string]bool)
+		for i := 0; i < 100; i++ {
+			templates, err := spawnTables.Draw("spawns.level_monsters", depth, rng)
+			assert.Nil(t, err)
+			assert.True(t, len(templates) >= 2)
+
+			for _, template := range templates {
+				seen[depth][template] = true
+			}
+		}
+	}
+
+	assert.Equal(t, map[string]bool{"level_1.small_rat": true, "level_1.large_rat": true}, seen[1])
+	assert.Equal(t, map[string]bool{"level_1.small_rat": true, "level_1.ghost_rat": true}, seen[5])
+
+	_, err = spawnTables.Draw("spawns.does_not_exist", 1, rng)
+	assert.NotNil(t, err)
+
+	// Templates that do not exist are reported
+	assert.NotNil(t, spawnTables.CheckTemplates(NewTemplateLibrary()))
+}
+
+func TestSpawnTables_LoadErrors(t *testing.T) {
+	spawnTables := NewSpawnTables()
+
+	err := spawnTables.Load(templateSources(t, map[string]string{"spawns": `{
+	  "bad": {"entries": [
+	    {"template": "a", "table": "b"},
+	    {"template": "a", "weight": -1, "count": "2d"},
+	    {"template": "a", "colour": "red"},
+	    "a"
+	  ]},
+	  "missing": {"entries": [{"table": "nowhere"}]}
+	}`}))
+	assert.NotNil(t, err)
+	assert.Equal(t, 6, len(err.(ErrorList)))
+	assert.Equal(t, "file spawns, definition bad, field entries[0]: an entry must name either a template or a table", err.(ErrorList)[0].Error())
+
+	err = spawnTables.Load(templateSources(t, map[string]string{"spawns": `{
+	  "a": {"entries": [{"table": "b"}]},
+	  "b": {"entries": [{"table": "a"}]}
+	}`}))
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "a -> b -> a")
+
+	err = spawnTables.Load(templateSources(t, map[string]string{"one": spawnTableData, "two": spawnTableData}))
+	assert.NotNil(t, err)
+	assert.Empty(t, spawnTables.Paths())
+}
+
+func TestEntityLoader_SpawnFromTable(t *testing.T) {
+	controller := ecs.NewController()
+	controller.MapComponentClass("position", PositionComponent{})
+	controller.MapComponentClass("appearance", AppearanceComponent{})
+
+	library := NewTemplateLibrary()
+	assert.Nil(t, library.Load(templateSources(t, map[string]string{"base": baseTemplates, "rats": ratTemplates})))
+
+	entityLoader := NewEntityLoader(controller)
+	entityLoader.SetTemplateLibrary(library)
+
+	spawnTables := NewSpawnTables()
+	assert.Nil(t, spawnTables.Load(templateSources(t, map[string]string{"spawns": `{
+	  "rats": {"entries": [{"template": "level_1.small_rat", "count": 3}]},
+	  "rat_swarm": {"entries": [{"template": "level_1.small_rat", "count": 20}]}
+	}`})))
+
+	// A 4x1 strip of floor, with one tile blocked
+	surface := &gamemap.GameMap{Width: 4, Height: 1}
+	surface.InitializeMap()
+	for x := 0; x < 4; x++ {
+		surface.Tiles[x][0] = &gamemap.Tile{X: x, Y: 0, Blocked: x == 2}
+		surface.FloorTiles = append(surface.FloorTiles, surface.Tiles[x][0])
+	}
+
+	entities, err := entityLoader.SpawnFromTable(spawnTables, "rats", 1, surface, randomnumbergenerator.NewSeededRNG(1))
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(entities))
+
+	occupied := make(map[int]bool)
+	for _, entity := range entities {
+		position := controller.GetComponent(entity, PositionComponent{}.TypeOf()).(PositionComponent)
+		assert.Equal(t, 0, position.Y)
+		assert.NotEqual(t, 2, position.X)
+		occupied[position.X] = true
+	}
+	assert.Equal(t, 3, len(occupied))
+
+	// There are only three free tiles, so the rest of the swarm cannot be placed
+	entities, err = entityLoader.SpawnFromTable(spawnTables, "rat_swarm", 1, surface, randomnumbergenerator.NewSeededRNG(1))
+	assert.NotNil(t, err)
+	assert.Equal(t, 3, len(entities))
+
+	_, err = entityLoader.SpawnFromTable(spawnTables, "does_not_exist", 1, surface, randomnumbergenerator.NewSeededRNG(1))
+	assert.NotNil(t, err)
+}
+
+type WeaponComponent struct {
+	Damage randomnumbergenerator.Dice
+}
+
+func (wc WeaponComponent) TypeOf() reflect.Type {
+	return reflect.TypeOf(wc)
+}
+
+func TestEntityLoader_DecodeDice(t *testing.T) {
+	controller := ecs.NewController()
+	controller.MapComponentClass("weapon", WeaponComponent{})
+
+	entityLoader := NewEntityLoader(controller)
+
+	entity, err := entityLoader.CreateEntityFromData(map[string]interface{}{"components": map[string]interface{}{
+		"weapon": map[string]interface{}{"Damage": "2d6+1"},
+	}})
+	assert.Nil(t, err)
+
+	weapon := controller.GetComponent(entity, WeaponComponent{}.TypeOf()).(WeaponComponent)
+	assert.Equal(t, randomnumbergenerator.Dice{Count: 2, Sides: 6, Modifier: 1}, weapon.Damage)
+	assert.Equal(t, "2d6+1", weapon.Damage.String())
+	assert.Equal(t, 3, weapon.Damage.Min())
+	assert.Equal(t, 13, weapon.Damage.Max())
+
+	for _, expression := range []interface{}{"d20", "3d8-2", "4", float64(4)} {
+		_, err := decodeDice(expression)
+		assert.Nil(t, err, expression)
+	}
+
+	for _, expression := range []interface{}{"", "2d", "xd6", "2d6+", "d0", 1.5, true} {
+		_, err := decodeDice(expression)
+		assert.NotNil(t, err, expression)
+	}
+}
@@ -0,0 +1,369 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gogue-framework/gogue/randomnumbergenerator"
+	"github.com/gogue-framework/gogue/ui"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// DecoderFunc turns a value loaded from a data file (a float64, string, bool, nil, []interface{}, or
+// map[string]interface{}) into a value of a specific type. Decoders are used for types that cannot be filled in field by
+// field, such as interfaces (ui.Glyph, for example), or types with a more convenient representation in a data file,
+// such as a dice expression stored as a string.
+type DecoderFunc func(value interface{}) (interface{}, error)
+
+// RegisterDecoder registers a decoder for a type on the EntityLoader. Any component field of that type (including
+// nested fields, and the elements of slices and maps) is decoded by the decoder, instead of being filled in field by
+// field. Registering a decoder for a type that already has one replaces it. Decoders for ui.Glyph, which reads Char,
+// Color, and ExploredColor, and randomnumbergenerator.Dice, which reads a dice expression such as "2d6+1", are
+// registered by default.
+func (el *EntityLoader) RegisterDecoder(valueType reflect.Type, decoder DecoderFunc) {
+	el.decoders[valueType] = decoder
+}
+
+// RegisterDecoderFor is the generic equivalent of EntityLoader.RegisterDecoder, for a decoder of values of type T
+// Example:
+// data.RegisterDecoderFor(entityLoader, func(value interface{}) (Dice, error) { return ParseDice(value.(string)) })
+func RegisterDecoderFor[T any](el *EntityLoader, decoder func(value interface{}) (T, error)) {
+	el.RegisterDecoder(reflect.TypeOf((*T)(nil)).Elem(), func(value interface{}) (interface{}, error) {
+		return decoder(value)
+	})
+}
+
+// defaultDecoders returns the decoders every EntityLoader starts out with
+func defaultDecoders() map[reflect.Type]DecoderFunc {
+	return map[reflect.Type]DecoderFunc{
+		reflect.TypeOf((*ui.Glyph)(nil)).Elem():                   decodeGlyph,
+		reflect.TypeOf((*randomnumbergenerator.Dice)(nil)).Elem(): decodeDice,
+	}
+}
+
+// decodeGlyph decodes a ui.Glyph. Glyphs are a little weird. They don't expose any public setters, so we can't
+// dynamically discover and set their properties. We have to do it a bit more...manually
+func decodeGlyph(value interface{}) (interface{}, error) {
+	glyphValues, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected an object with Char and Color, got %v", describeValue(value))
+	}
+
+	properties := map[string]string{}
+	for _, propertyName := range sortedKeys(glyphValues) {
+		propertyValue := glyphValues[propertyName]
+		if propertyName != "Char" && propertyName != "Color" && propertyName != "ExploredColor" {
+			return nil, fmt.Errorf("unknown glyph property %v", propertyName)
+		}
+
+		text, ok := propertyValue.(string)
+		if !ok {
+			return nil, fmt.Errorf("glyph property %v must be a string, got %v", propertyName, describeValue(propertyValue))
+		}
+
+		properties[propertyName] = text
+	}
+
+	return ui.NewGlyph(properties["Char"], properties["Color"], properties["ExploredColor"]), nil
+}
+
+// decodeValue sets target from a loaded value, recursing into structs, slices, arrays, maps, and pointers. Problems
+// are collected, rather than stopping at the first one, and each is reported with the path to the field it was found
+// in (ie "Stats.Strength", or "Inventory[2]").
+func (el *EntityLoader) decodeValue(target reflect.Value, value interface{}, path string) []*DataError {
+	fail := func(err error) []*DataError {
+		return []*DataError{{Field: path, Err: err}}
+	}
+
+	if decoder, ok := el.decoders[target.Type()]; ok {
+		decoded, err := decoder(value)
+		if err != nil {
+			return fail(err)
+		}
+
+		if decoded == nil {
+			target.Set(reflect.Zero(target.Type()))
+			return nil
+		}
+
+		decodedValue := reflect.ValueOf(decoded)
+		if !decodedValue.Type().AssignableTo(target.Type()) {
+			if !decodedValue.Type().ConvertibleTo(target.Type()) {
+				return fail(fmt.Errorf("decoder returned a %v, which cannot be used as a %v", decodedValue.Type(), target.Type()))
+			}
+			decodedValue = decodedValue.Convert(target.Type())
+		}
+
+		target.Set(decodedValue)
+		return nil
+	}
+
+	// A null value resets the field
+	if value == nil {
+		target.Set(reflect.Zero(target.Type()))
+		return nil
+	}
+
+	switch target.Kind() {
+	case reflect.Bool:
+		flag, ok := value.(bool)
+		if !ok {
+			return fail(fmt.Errorf("expected true or false, got %v", describeValue(value)))
+		}
+
+		target.SetBool(flag)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		number, err := wholeNumber(value)
+		if err != nil {
+			return fail(err)
+		}
+
+		if number < math.MinInt64 || number >= math.MaxInt64 || target.OverflowInt(int64(number)) {
+			return fail(fmt.Errorf("%v does not fit in a field of type %v", number, target.Type()))
+		}
+
+		target.SetInt(int64(number))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		number, err := wholeNumber(value)
+		if err != nil {
+			return fail(err)
+		}
+
+		if number < 0 || number >= math.MaxUint64 || target.OverflowUint(uint64(number)) {
+			return fail(fmt.Errorf("%v does not fit in a field of type %v", number, target.Type()))
+		}
+
+		target.SetUint(uint64(number))
+	case reflect.Float32, reflect.Float64:
+		number, ok := value.(float64)
+		if !ok {
+			return fail(fmt.Errorf("expected a number, got %v", describeValue(value)))
+		}
+
+		if target.OverflowFloat(number) {
+			return fail(fmt.Errorf("%v does not fit in a field of type %v", number, target.Type()))
+		}
+
+		target.SetFloat(number)
+	case reflect.String:
+		text, ok := value.(string)
+		if !ok {
+			return fail(fmt.Errorf("expected a string, got %v", describeValue(value)))
+		}
+
+		target.SetString(text)
+	case reflect.Slice:
+		list, ok := value.([]interface{})
+		if !ok {
+			return fail(fmt.Errorf("expected a list, got %v", describeValue(value)))
+		}
+
+		slice := reflect.MakeSlice(target.Type(), len(list), len(list))
+		errs := []*DataError{}
+		for i, item := range list {
+			errs = append(errs, el.decodeValue(slice.Index(i), item, fmt.Sprintf("%v[%v]", path, i))...)
+		}
+
+		target.Set(slice)
+		return errs
+	case reflect.Array:
+		list, ok := value.([]interface{})
+		if !ok {
+			return fail(fmt.Errorf("expected a list, got %v", describeValue(value)))
+		}
+
+		if len(list) > target.Len() {
+			return fail(fmt.Errorf("expected at most %v items, got %v", target.Len(), len(list)))
+		}
+
+		array := reflect.New(target.Type()).Elem()
+		errs := []*DataError{}
+		for i, item := range list {
+			errs = append(errs, el.decodeValue(array.Index(i), item, fmt.Sprintf("%v[%v]", path, i))...)
+		}
+
+		target.Set(array)
+		return errs
+	case reflect.Map:
+		return el.decodeMap(target, value, path)
+	case reflect.Struct:
+		return el.decodeStruct(target, value, path)
+	case reflect.Ptr:
+		pointer := reflect.New(target.Type().Elem())
+		errs := el.decodeValue(pointer.Elem(), value, path)
+
+		target.Set(pointer)
+		return errs
+	case reflect.Interface:
+		// Interfaces can only be decoded if any value will do, otherwise there is no way of knowing what type to create
+		if target.NumMethod() > 0 {
+			return fail(fmt.Errorf("no decoder has been registered for %v", target.Type()))
+		}
+
+		target.Set(reflect.ValueOf(copyValue(value)))
+	default:
+		return fail(fmt.Errorf("fields of type %v are not supported", target.Type()))
+	}
+
+	return nil
+}
+
+// decodeMap fills a map from an object. Map keys may be strings, or numbers written as strings.
+func (el *EntityLoader) decodeMap(target reflect.Value, value interface{}, path string) []*DataError {
+	object, ok := value.(map[string]interface{})
+	if !ok {
+		return []*DataError{{Field: path, Err: fmt.Errorf("expected an object, got %v", describeValue(value))}}
+	}
+
+	mapType := target.Type()
+	result := reflect.MakeMapWithSize(mapType, len(object))
+	errs := []*DataError{}
+
+	for _, key := range sortedKeys(object) {
+		keyPath := joinFieldPath(path, key)
+
+		mapKey, err := decodeMapKey(mapType.Key(), key)
+		if err != nil {
+			errs = append(errs, &DataError{Field: keyPath, Err: err})
+			continue
+		}
+
+		mapValue := reflect.New(mapType.Elem()).Elem()
+		errs = append(errs, el.decodeValue(mapValue, object[key], keyPath)...)
+		result.SetMapIndex(mapKey, mapValue)
+	}
+
+	target.Set(result)
+	return errs
+}
+
+// decodeMapKey converts an object key into a map key of the given type
+func decodeMapKey(keyType reflect.Type, key string) (reflect.Value, error) {
+	mapKey := reflect.New(keyType).Elem()
+
+	switch keyType.Kind() {
+	case reflect.String:
+		mapKey.SetString(key)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		number, err := strconv.ParseInt(key, 10, keyType.Bits())
+		if err != nil {
+			return mapKey, fmt.Errorf("key %v is not a valid %v", key, keyType)
+		}
+		mapKey.SetInt(number)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		number, err := strconv.ParseUint(key, 10, keyType.Bits())
+		if err != nil {
+			return mapKey, fmt.Errorf("key %v is not a valid %v", key, keyType)
+		}
+		mapKey.SetUint(number)
+	default:
+		return mapKey, fmt.Errorf("maps with keys of type %v are not supported", keyType)
+	}
+
+	return mapKey, nil
+}
+
+// decodeStruct fills in the fields of a struct from an object. Every property in the object must match a field, see
+// structFields for how fields are named.
+func (el *EntityLoader) decodeStruct(target reflect.Value, value interface{}, path string) []*DataError {
+	object, ok := value.(map[string]interface{})
+	if !ok {
+		return []*DataError{{Field: path, Err: fmt.Errorf("expected an object, got %v", describeValue(value))}}
+	}
+
+	fields := structFields(target.Type())
+	errs := []*DataError{}
+
+	for _, propertyName := range sortedKeys(object) {
+		fieldPath := joinFieldPath(path, propertyName)
+
+		index, ok := fields[propertyName]
+		if !ok {
+			errs = append(errs, &DataError{Field: fieldPath, Err: errors.New("unknown field")})
+			continue
+		}
+
+		errs = append(errs, el.decodeValue(target.Field(index), object[propertyName], fieldPath)...)
+	}
+
+	return errs
+}
+
+// structFields returns the index of every field of a struct type that can be set from a data file, keyed by the name
+// used for it in data files. This is the name given in a `gogue:"name"` tag if there is one, the name given in a json
+// tag otherwise, and the field name if neither is present. Unexported fields, and fields tagged with "-", are skipped.
+func structFields(structType reflect.Type) map[string]int {
+	fields := make(map[string]int)
+
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+
+		if field.PkgPath != "" {
+			continue
+		}
+
+		name := field.Name
+		for _, tagName := range []string{"gogue", "json"} {
+			if tag, ok := field.Tag.Lookup(tagName); ok {
+				tag = strings.Split(tag, ",")[0]
+				if tag != "" {
+					name = tag
+				}
+				break
+			}
+		}
+
+		if name == "-" {
+			continue
+		}
+
+		fields[name] = i
+	}
+
+	return fields
+}
+
+// wholeNumber returns a loaded value as a whole number, or an error if it is not one
+func wholeNumber(value interface{}) (float64, error) {
+	number, ok := value.(float64)
+	if !ok {
+		return 0, fmt.Errorf("expected a number, got %v", describeValue(value))
+	}
+
+	if number != math.Trunc(number) {
+		return 0, fmt.Errorf("expected a whole number, got %v", number)
+	}
+
+	return number, nil
+}
+
+// joinFieldPath adds a field name to the path of its parent
+func joinFieldPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+
+	return path + "." + name
+}
+
+// describeValue describes the type of a value loaded from a data file, for use in error messages
+func describeValue(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case float64:
+		return "a number"
+	case string:
+		return "a string"
+	case bool:
+		return "true or false"
+	case []interface{}:
+		return "a list"
+	case map[string]interface{}:
+		return "an object"
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
@@ -1,9 +1,11 @@
 package data
 
 import (
+	"errors"
+	"fmt"
 	"github.com/gogue-framework/gogue/ecs"
-	"github.com/gogue-framework/gogue/ui"
 	"reflect"
+	"sync"
 )
 
 // EntityLoader responsible for taking loaded data from the text files (in the form of a map of strings), and turning
@@ -11,14 +13,30 @@ import (
 // definition files, and we now want to use those in the game. In order to do that, we would find the potion we want
 // to load, and then take that definition and turn it into an entity in the ECS. We can do this as many times as we
 // need per potion definition. In this way, we have an easy way of loading data file information into the ECS.
+// Entities can also be created from a TemplateLibrary, by template path (see CreateFromTemplate), or spawned onto a map
+// from a spawn table (see SpawnFromTable).
+// Component fields are filled in from the data by name (see structFields for how names can be changed with struct
+// tags), and any type can be loaded, including nested structs, slices, maps, and pointers. Types that cannot be loaded
+// field by field, such as interfaces, can be given a decoder (see RegisterDecoder).
 type EntityLoader struct {
-	controller *ecs.Controller
+	controller        *ecs.Controller
+	templates         *TemplateLibrary
+	decoders          map[reflect.Type]DecoderFunc
+	spawned           map[ecs.EntityHandle]*spawnedEntity
+	positionComponent string
+	mutex             sync.Mutex
+	reloadMutex       sync.Mutex
 }
 
 // NewEntityLoader creates a new instance of an EntityLoader
 func NewEntityLoader(controller *ecs.Controller) *EntityLoader {
 	entityLoader := EntityLoader{}
 	entityLoader.controller = controller
+	entityLoader.decoders = defaultDecoders()
+	entityLoader.spawned = make(map[ecs.EntityHandle]*spawnedEntity)
+	entityLoader.positionComponent = "position"
+
+	controller.OnEntityDeleted(entityLoader.forgetSpawned)
 
 	return &entityLoader
 }
@@ -26,36 +44,155 @@ func NewEntityLoader(controller *ecs.Controller) *EntityLoader {
 // CreateSingleEntity takes a map of generic interface data (returned from Gogues data loader), and creates a single
 // instance entity out of it. It will add any indicated components, and any data associated with those components. This
 // will return the entity ID.
+// CreateSingleEntity is best effort: components that have not been mapped on the controller are skipped, and fields
+// that cannot be set from the data are left at their zero value. If the data has no components, no entity is created,
+// and -1 is returned. Nothing is reported about skipped components or fields, use CreateEntityFromData to find out what
+// went wrong.
 func (el *EntityLoader) CreateSingleEntity(data map[string]interface{}) int {
-	// First, check to ensure there is a components property in the map. If this is not present, we cannot continue
-	if _, ok := data["components"]; ok {
-		componentList := data["components"].(map[string]interface{})
+	// Problems with the data are deliberately ignored here, CreateEntityFromData reports them
+	components, _ := el.buildComponents(data)
 
-		// Create a new entity
-		newEntity := el.controller.CreateEntity([]ecs.Component{})
+	if components == nil {
+		return -1
+	}
 
-		for componentName, values := range componentList {
-			// Grab the component type off the controller. Also, ensure that this component type has been registered
-			// with the controller
-			component := el.controller.GetMappedComponentClass(componentName)
+	return el.controller.CreateEntity(components)
+}
 
-			if component != nil {
-				newComponentValue := el.getInterfaceValue(component)
+// CreateEntityFromData works the same way as CreateSingleEntity, but is strict about the data: if there are no
+// components, a component has not been mapped on the controller, or a field cannot be set from the data, no entity is
+// created, and every problem found is returned as an ErrorList of DataErrors.
+func (el *EntityLoader) CreateEntityFromData(data map[string]interface{}) (int, error) {
+	components, errs := el.buildComponents(data)
 
-				valuesMap := values.(map[string]interface{})
-				el.setFieldValues(valuesMap, newComponentValue)
-				// Finally, update the new component with the changes we made based on the property values
-				newComponentInterface := newComponentValue.Interface()
-				updatedNewComponent := newComponentInterface
+	if len(errs) > 0 {
+		return -1, errs
+	}
+
+	return el.controller.CreateEntity(components), nil
+}
 
-				// Add the component to the created entity
-				el.controller.AddComponent(newEntity, updatedNewComponent.(ecs.Component))
+// buildComponents creates the components described by an entity definition. It returns the components it was able to
+// create, along with every problem it ran into. The list of components is nil if the definition has no components.
+func (el *EntityLoader) buildComponents(data map[string]interface{}) ([]ecs.Component, ErrorList) {
+	errs := ErrorList{}
+
+	// First, check to ensure there is a components property in the map. If this is not present, we cannot continue
+	componentList, ok := data["components"].(map[string]interface{})
+	if !ok {
+		errs = append(errs, &DataError{Err: errors.New("definition has no components")})
+		return nil, errs
+	}
+
+	components := []ecs.Component{}
+
+	for _, componentName := range sortedKeys(componentList) {
+		// Grab the component type off the controller. Also, ensure that this component type has been registered
+		// with the controller
+		if !el.controller.HasMappedComponent(componentName) {
+			errs = append(errs, &DataError{Component: componentName, Err: errors.New("component has not been mapped on the controller")})
+			continue
+		}
+
+		component := el.controller.GetMappedComponentClass(componentName)
+		newComponentValue := el.getInterfaceValue(component)
+
+		// A component without any values (ie a flag) is left as is
+		if values := componentList[componentName]; values != nil {
+			for _, err := range el.decodeValue(newComponentValue, values, "") {
+				err.Component = componentName
+				errs = append(errs, err)
 			}
 		}
 
-		return newEntity
+		// Finally, update the new component with the changes we made based on the property values
+		components = append(components, newComponentValue.Interface())
 	}
-	return -1
+
+	return components, errs
+}
+
+// SetTemplateLibrary sets the TemplateLibrary used by CreateFromTemplate
+func (el *EntityLoader) SetTemplateLibrary(templates *TemplateLibrary) {
+	el.mutex.Lock()
+	defer el.mutex.Unlock()
+
+	el.templates = templates
+}
+
+// CreateFromTemplate creates a single entity from the template at the given path (ie "level_1.large_rat"), and returns
+// the entity ID. overrides is an optional map of component names to component values, which is merged on top of the
+// templates components before the entity is created, in the same way a template is merged on top of its parents. This
+// allows, for example, naming an entity as it is created:
+// entity, err := entityLoader.CreateFromTemplate("level_1.large_rat", map[string]interface{}{"appearance": map[string]interface{}{"Name": "Ratticus"}})
+// The EntityLoader is not locked while the entity is created, so the controllers observers are free to create entities
+// from templates themselves.
+func (el *EntityLoader) CreateFromTemplate(path string, overrides map[string]interface{}) (int, error) {
+	el.mutex.Lock()
+	components, templateComponents, err := el.buildTemplate(path, overrides)
+	el.mutex.Unlock()
+
+	if err != nil {
+		return -1, err
+	}
+
+	entity := el.controller.CreateEntity(components)
+	handle := el.controller.GetHandle(entity)
+
+	// Remember where the entity came from, so it can be updated if its template is reloaded. An observer may already
+	// have deleted it, in which case there is nothing to remember.
+	el.mutex.Lock()
+	if el.controller.IsAlive(handle) {
+		el.spawned[handle] = &spawnedEntity{
+			path:       path,
+			components: templateComponents,
+			overrides:  copyValue(overrides).(map[string]interface{}),
+		}
+	}
+	el.mutex.Unlock()
+
+	return entity, nil
+}
+
+// forgetSpawned stops tracking an entity created from a template once it has been deleted, so that its ID can be
+// reused by an entity that has nothing to do with the template
+func (el *EntityLoader) forgetSpawned(entity int) {
+	handle := el.controller.GetHandle(entity)
+
+	el.mutex.Lock()
+	defer el.mutex.Unlock()
+
+	delete(el.spawned, handle)
+}
+
+// buildTemplate creates the components for an entity from the template at the given path, with the overrides merged
+// on top. It also returns the component data the components were built from. The EntityLoader lock must be held.
+func (el *EntityLoader) buildTemplate(path string, overrides map[string]interface{}) ([]ecs.Component, map[string]interface{}, error) {
+	if el.templates == nil {
+		return nil, nil, fmt.Errorf("cannot create %v, no TemplateLibrary has been set on the EntityLoader", path)
+	}
+
+	template, ok := el.templates.Template(path)
+	if !ok {
+		return nil, nil, fmt.Errorf("template %v does not exist", path)
+	}
+
+	components, ok := template["components"].(map[string]interface{})
+	if !ok {
+		return nil, nil, fmt.Errorf("template %v does not define any components", path)
+	}
+
+	if overrides != nil {
+		components = mergeValues(components, overrides)
+		template["components"] = components
+	}
+
+	built, errs := el.buildComponents(template)
+	if len(errs) > 0 {
+		return nil, nil, errs.withLocation(el.templates.source(path), path)
+	}
+
+	return built, components, nil
 }
 
 // getInterfaceValue returns the reflect.Value of a generic interface
@@ -70,38 +207,3 @@ func (el *EntityLoader) getInterfaceValue(reflectedInterface interface{}) reflec
 
 	return newInterfaceValue
 }
-
-// setFieldValues will dynamically set interface property values for a given generic interface reflect.Value.
-func (el *EntityLoader) setFieldValues(values map[string]interface{}, value reflect.Value) {
-	for propertyName, propertyValue := range values {
-		field := value.FieldByName(propertyName)
-
-		if field.IsValid() && field.CanSet() {
-			if field.Kind() == reflect.Int64 {
-				field.SetInt(propertyValue.(int64))
-			} else if field.Kind() == reflect.Float64 {
-				field.SetFloat(propertyValue.(float64))
-			} else if field.Kind() == reflect.String {
-				field.SetString(propertyValue.(string))
-			} else if field.Kind() == reflect.Interface {
-				// There are only a few nested interface properties, so we'll just handle them manually
-				if propertyName == "Glyph" {
-					// Glyphs are a little weird. They don't expose any public setters, so we can't dynamically discover
-					// and set their properties. We have to do it a bit more...manually
-					glyphValues := propertyValue.(map[string]interface{})
-					color := ""
-					char := ""
-					for glyphPropName, glyphPropValue := range glyphValues {
-						if glyphPropName == "Color" {
-							color = glyphPropValue.(string)
-						} else if glyphPropName == "Char" {
-							char = glyphPropValue.(string)
-						}
-					}
-					glyph := ui.NewGlyph(char, color, "")
-					field.Set(reflect.ValueOf(glyph))
-				}
-			}
-		}
-	}
-}
@@ -0,0 +1,90 @@
+package data
+
+import (
+	"strings"
+)
+
+// DataError describes a problem with a piece of loaded data. It names as much as is known about where the problem is:
+// the file it was loaded from, the path of the definition within the file (ie "level_1.small_rat"), and the component
+// and field that could not be loaded. Any of these may be empty, if they are not known, or do not apply. Err is the
+// underlying error.
+type DataError struct {
+	File      string
+	Path      string
+	Component string
+	Field     string
+	Err       error
+}
+
+// Error returns a description of the error, prefixed with its location
+func (de *DataError) Error() string {
+	location := []string{}
+
+	if de.File != "" {
+		location = append(location, "file "+de.File)
+	}
+
+	if de.Path != "" {
+		location = append(location, "definition "+de.Path)
+	}
+
+	if de.Component != "" {
+		location = append(location, "component "+de.Component)
+	}
+
+	if de.Field != "" {
+		location = append(location, "field "+de.Field)
+	}
+
+	if len(location) == 0 {
+		return de.Err.Error()
+	}
+
+	return strings.Join(location, ", ") + ": " + de.Err.Error()
+}
+
+// Unwrap returns the underlying error, so that DataErrors work with errors.Is and errors.As
+func (de *DataError) Unwrap() error {
+	return de.Err
+}
+
+// ErrorList is a list of errors, returned when loading several pieces of data, so that every problem can be reported at
+// once, rather than just the first one.
+type ErrorList []error
+
+// Error returns the description of every error in the list, one per line
+func (el ErrorList) Error() string {
+	messages := []string{}
+	for _, err := range el {
+		messages = append(messages, err.Error())
+	}
+
+	return strings.Join(messages, "\n")
+}
+
+// errorOrNil returns the list as an error, or nil if the list is empty. This avoids returning a non-nil error interface
+// holding an empty list.
+func (el ErrorList) errorOrNil() error {
+	if len(el) == 0 {
+		return nil
+	}
+
+	return el
+}
+
+// withLocation fills in the file and definition path of any DataErrors in the list that do not have one yet
+func (el ErrorList) withLocation(file, path string) ErrorList {
+	for _, err := range el {
+		if dataError, ok := err.(*DataError); ok {
+			if dataError.File == "" {
+				dataError.File = file
+			}
+
+			if dataError.Path == "" {
+				dataError.Path = path
+			}
+		}
+	}
+
+	return el
+}
@@ -0,0 +1,129 @@
+package data
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// FormatDecoder parses the contents of a data file into a map. Whatever the format, the map must follow the same shape
+// as decoded JSON, as that is what the rest of the data package works with: nested objects are map[string]interface{},
+// lists are []interface{}, and all numbers are float64. normalizeValue can be used to convert decoded data to this
+// shape.
+type FormatDecoder func(contents []byte) (map[string]interface{}, error)
+
+// defaultFormats returns the FormatDecoders every FileLoader starts out with, keyed by file extension
+func defaultFormats() map[string]FormatDecoder {
+	return map[string]FormatDecoder{
+		".json": decodeJSON,
+		".yaml": decodeYAML,
+		".yml":  decodeYAML,
+		".toml": decodeTOML,
+	}
+}
+
+// RegisterFormat registers a FormatDecoder for files with the given extension (ie ".json"), replacing any decoder
+// already registered for it. JSON (.json), YAML (.yaml and .yml), and TOML (.toml) are supported out of the box.
+func (fl *FileLoader) RegisterFormat(extension string, decoder FormatDecoder) {
+	fl.formats[normalizeExtension(extension)] = decoder
+}
+
+// formatFor returns the FormatDecoder for a file, based on its extension
+func (fl *FileLoader) formatFor(fileName string) (FormatDecoder, bool) {
+	decoder, ok := fl.formats[normalizeExtension(filepath.Ext(fileName))]
+	return decoder, ok
+}
+
+// normalizeExtension lower cases an extension, and makes sure it starts with a dot
+func normalizeExtension(extension string) string {
+	extension = strings.ToLower(extension)
+	if extension != "" && !strings.HasPrefix(extension, ".") {
+		extension = "." + extension
+	}
+
+	return extension
+}
+
+// decodeJSON decodes a JSON data file
+func decodeJSON(contents []byte) (map[string]interface{}, error) {
+	var result map[string]interface{}
+
+	err := json.Unmarshal(contents, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// decodeYAML decodes a YAML data file
+func decodeYAML(contents []byte) (map[string]interface{}, error) {
+	var result map[string]interface{}
+
+	err := yaml.Unmarshal(contents, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	return normalizeValue(result).(map[string]interface{}), nil
+}
+
+// decodeTOML decodes a TOML data file
+func decodeTOML(contents []byte) (map[string]interface{}, error) {
+	var result map[string]interface{}
+
+	err := toml.Unmarshal(contents, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	return normalizeValue(result).(map[string]interface{}), nil
+}
+
+// normalizeValue converts a value decoded from a data file into the same shape as decoded JSON. Maps with non string
+// keys (as produced by YAML) are converted to map[string]interface{}, lists of any kind to []interface{}, every kind
+// of number to float64, and times (as produced by YAML and TOML) to RFC 3339 strings.
+func normalizeValue(value interface{}) interface{} {
+	switch typed := value.(type) {
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(typed))
+		for key, item := range typed {
+			result[key] = normalizeValue(item)
+		}
+		return result
+	case map[interface{}]interface{}:
+		result := make(map[string]interface{}, len(typed))
+		for key, item := range typed {
+			result[fmt.Sprint(key)] = normalizeValue(item)
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(typed))
+		for i, item := range typed {
+			result[i] = normalizeValue(item)
+		}
+		return result
+	case []map[string]interface{}:
+		result := make([]interface{}, len(typed))
+		for i, item := range typed {
+			result[i] = normalizeValue(item)
+		}
+		return result
+	case int:
+		return float64(typed)
+	case int64:
+		return float64(typed)
+	case uint64:
+		return float64(typed)
+	case float32:
+		return float64(typed)
+	case time.Time:
+		return typed.Format(time.RFC3339Nano)
+	default:
+		return value
+	}
+}
@@ -0,0 +1,195 @@
+package data
+
+import (
+	"errors"
+	"github.com/gogue-framework/gogue/ecs"
+	"reflect"
+	"sort"
+)
+
+// spawnedEntity records an entity created from a template, so that it can be updated when the template is reloaded.
+// components holds the component values the entity was last built from, including any overrides it was created with.
+type spawnedEntity struct {
+	path       string
+	components map[string]interface{}
+	overrides  map[string]interface{}
+}
+
+// ReloadTemplates loads new template data (as returned from FileLoader.LoadAllFromFiles) into the EntityLoaders
+// TemplateLibrary, creating a library if none has been set yet. If the data cannot be loaded, the error is returned,
+// and the existing templates are kept.
+// If updateSpawned is true, entities that were created with CreateFromTemplate are updated to match their new template.
+// Only what has changed in the template is changed on the entity: components that were added to the template are
+// added, components that were removed are removed, and only the fields of a component that have changed are set. Any
+// other changes made to the entity since it was created (damage taken, for example) are kept, as are the overrides
+// the entity was created with.
+func (el *EntityLoader) ReloadTemplates(sources map[string]map[string]interface{}, updateSpawned bool) error {
+	// Only one reload can run at a time, but the EntityLoader itself is not locked while spawned entities are updated,
+	// so the controllers observers are free to create entities from templates
+	el.reloadMutex.Lock()
+	defer el.reloadMutex.Unlock()
+
+	el.mutex.Lock()
+	if el.templates == nil {
+		el.templates = NewTemplateLibrary()
+	}
+	templates := el.templates
+	el.mutex.Unlock()
+
+	err := templates.Load(sources)
+	if err != nil {
+		return err
+	}
+
+	if !updateSpawned {
+		return nil
+	}
+
+	el.mutex.Lock()
+	handles := []ecs.EntityHandle{}
+	spawnedEntities := make(map[ecs.EntityHandle]*spawnedEntity)
+	for handle, spawned := range el.spawned {
+		handles = append(handles, handle)
+		spawnedEntities[handle] = spawned
+	}
+	el.mutex.Unlock()
+
+	sort.Slice(handles, func(i, j int) bool {
+		return handles[i].ID < handles[j].ID
+	})
+
+	errs := ErrorList{}
+	for _, handle := range handles {
+		// Entities deleted since the list was taken (by an observer, say) are skipped
+		if !el.controller.IsAlive(handle) {
+			continue
+		}
+
+		errs = append(errs, el.updateSpawned(templates, handle.ID, spawnedEntities[handle])...)
+	}
+
+	return errs.errorOrNil()
+}
+
+// WatchTemplates subscribes the EntityLoader to a Watcher, so that its templates are reloaded whenever the data files
+// change (see ReloadTemplates). If the changed files cannot be loaded, or spawned entities cannot be updated, the
+// existing templates are kept, and the error is passed to onError, so the game can report it however it likes. If
+// onError is nil, errors are ignored.
+func (el *EntityLoader) WatchTemplates(watcher *Watcher, updateSpawned bool, onError func(err error)) {
+	watcher.Subscribe(func(event ReloadEvent) {
+		err := event.Err
+		if err == nil {
+			err = el.ReloadTemplates(event.Data, updateSpawned)
+		}
+
+		if err != nil && onError != nil {
+			onError(err)
+		}
+	})
+}
+
+// updateSpawned brings a spawned entity up to date with its template. The reload lock must be held, but not the
+// EntityLoader lock, as changing the entitys components calls the controllers observers.
+func (el *EntityLoader) updateSpawned(templates *TemplateLibrary, entity int, spawned *spawnedEntity) ErrorList {
+	template, ok := templates.Template(spawned.path)
+	if !ok {
+		// The template has been removed, leave the entity as it is, in case it comes back
+		return nil
+	}
+
+	components, ok := template["components"].(map[string]interface{})
+	if !ok {
+		components = make(map[string]interface{})
+	}
+	components = mergeValues(components, spawned.overrides)
+
+	errs := ErrorList{}
+	names := make(map[string]bool)
+	for componentName := range spawned.components {
+		names[componentName] = true
+	}
+	for componentName := range components {
+		names[componentName] = true
+	}
+
+	for _, componentName := range sortedKeys(names) {
+		previous, hadComponent := spawned.components[componentName]
+		current, hasComponent := components[componentName]
+
+		if hadComponent && hasComponent && reflect.DeepEqual(previous, current) {
+			continue
+		}
+
+		if !el.controller.HasMappedComponent(componentName) {
+			errs = append(errs, &DataError{Component: componentName, Err: errors.New("component has not been mapped on the controller")})
+			continue
+		}
+
+		componentType := reflect.TypeOf(el.controller.GetMappedComponentClass(componentName))
+
+		if !hasComponent {
+			el.controller.RemoveComponent(entity, componentType)
+			continue
+		}
+
+		// Start from the entitys current component, so that any changes made to it while playing are kept. If the
+		// component is new to the template, start from scratch.
+		existing := el.controller.GetComponent(entity, componentType)
+		if hadComponent && existing == nil {
+			// The component has been removed from the entity since it was created, so leave it that way
+			continue
+		}
+
+		componentValue := el.getInterfaceValue(el.controller.GetMappedComponentClass(componentName))
+		changes, isMap := current.(map[string]interface{})
+
+		if hadComponent {
+			componentValue.Set(reflect.ValueOf(existing))
+
+			previousValues, wasMap := previous.(map[string]interface{})
+			if isMap && wasMap {
+				changes = changedValues(previousValues, changes)
+			}
+		}
+
+		decodeErrs := []*DataError{}
+		if isMap {
+			decodeErrs = el.decodeValue(componentValue, changes, "")
+		} else if current != nil {
+			decodeErrs = el.decodeValue(componentValue, current, "")
+		}
+
+		for _, err := range decodeErrs {
+			err.Component = componentName
+			errs = append(errs, err)
+		}
+
+		if len(decodeErrs) == 0 {
+			el.controller.AddComponent(entity, componentValue.Interface().(ecs.Component))
+		}
+	}
+
+	spawned.components = components
+
+	return errs.withLocation(templates.source(spawned.path), spawned.path)
+}
+
+// changedValues returns the values in current that differ from previous. Values that have been removed from current are
+// returned as nil, so they are reset.
+func changedValues(previous, current map[string]interface{}) map[string]interface{} {
+	changes := make(map[string]interface{})
+
+	for key, value := range current {
+		if !reflect.DeepEqual(previous[key], value) {
+			changes[key] = value
+		}
+	}
+
+	for key := range previous {
+		if _, ok := current[key]; !ok {
+			changes[key] = nil
+		}
+	}
+
+	return changes
+}
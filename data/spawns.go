@@ -0,0 +1,471 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gogue-framework/gogue/gamemap"
+	"github.com/gogue-framework/gogue/randomnumbergenerator"
+	"strings"
+	"sync"
+)
+
+// entriesKey is the key in a block of data that marks it as a spawn table
+const entriesKey = "entries"
+
+// SpawnEntry is a single line of a SpawnTable. It names either a template to spawn, or another table to draw from, the
+// weight of the entry relative to the other entries in the table, the range of depths (dungeon levels) the entry can
+// be drawn on, and how many are spawned when the entry is drawn. A MaxDepth of zero (or less) means there is no
+// deepest level.
+type SpawnEntry struct {
+	Template string
+	Table    string
+	Weight   int
+	MinDepth int
+	MaxDepth int
+	Count    randomnumbergenerator.Dice
+}
+
+// SpawnTable is a weighted list of things that can be spawned. Rolls is how many times the table is drawn from each
+// time it is used.
+type SpawnTable struct {
+	Rolls   randomnumbergenerator.Dice
+	Entries []SpawnEntry
+}
+
+// SpawnTables holds spawn tables loaded from data files, addressed by a dotted path, in the same way as templates in a
+// TemplateLibrary. A block of data with an "entries" key is a spawn table, and every other block is treated as a group
+// of tables (definitions are skipped, so spawn tables can live in the same files as templates). Each entry names a
+// "template" or a nested "table" by its full path, and can have a "weight" (1 by default), a "min_depth" and
+// "max_depth", and a "count", which is a dice expression (1 by default). A table can also have a "rolls" dice
+// expression, for how many entries are drawn from it (1 by default).
+// Example:
+// "level_monsters": {"rolls": "2d3", "entries": [
+// {"template": "level_1.small_rat", "weight": 50, "count": "1d3"},
+// {"template": "level_1.goblin", "weight": 30, "min_depth": 2},
+// {"table": "rare_monsters", "weight": 5, "min_depth": 3}]}
+type SpawnTables struct {
+	tables  map[string]SpawnTable
+	origins map[string]string
+	mutex   sync.RWMutex
+}
+
+// NewSpawnTables creates a new, empty, set of SpawnTables
+func NewSpawnTables() *SpawnTables {
+	spawnTables := SpawnTables{}
+	spawnTables.tables = make(map[string]SpawnTable)
+	spawnTables.origins = make(map[string]string)
+
+	return &spawnTables
+}
+
+// Load reads all the spawn tables found in the given data, as returned from FileLoader.LoadAllFromFiles. It is an error
+// if an entry is malformed, names a table that does not exist, if tables include each other in a cycle, or if the same
+// path is defined more than once. Every problem found is returned, as an ErrorList of DataErrors. Load replaces any
+// tables loaded previously, but leaves them untouched if an error is returned.
+func (st *SpawnTables) Load(sources map[string]map[string]interface{}) error {
+	tables := make(map[string]SpawnTable)
+	origins := make(map[string]string)
+	errs := ErrorList{}
+
+	for _, sourceName := range sortedKeys(sources) {
+		errs = append(errs, collectSpawnTables(sourceName, "", sources[sourceName], tables, origins)...)
+	}
+
+	for _, path := range sortedKeys(tables) {
+		for i, entry := range tables[path].Entries {
+			if entry.Table == "" {
+				continue
+			}
+
+			if _, ok := tables[entry.Table]; !ok {
+				errs = append(errs, &DataError{File: origins[path], Path: path, Field: fmt.Sprintf("entries[%v].table", i), Err: fmt.Errorf("table %v does not exist", entry.Table)})
+			}
+		}
+	}
+
+	// Cycles are only worth looking for once every table exists
+	if len(errs) == 0 {
+		checked := make(map[string]bool)
+		for _, path := range sortedKeys(tables) {
+			if err := checkSpawnCycle(path, tables, checked, []string{}); err != nil {
+				err.File = origins[err.Path]
+				errs = append(errs, err)
+				break
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	st.mutex.Lock()
+	st.tables = tables
+	st.origins = origins
+	st.mutex.Unlock()
+
+	return nil
+}
+
+// Table returns the spawn table at the given path. The second return value is false if there is no table at that path.
+func (st *SpawnTables) Table(path string) (SpawnTable, bool) {
+	st.mutex.RLock()
+	defer st.mutex.RUnlock()
+
+	table, ok := st.tables[path]
+	if !ok {
+		return SpawnTable{}, false
+	}
+
+	table.Entries = append([]SpawnEntry{}, table.Entries...)
+	return table, true
+}
+
+// Paths returns the paths of every spawn table, in alphabetical order
+func (st *SpawnTables) Paths() []string {
+	st.mutex.RLock()
+	defer st.mutex.RUnlock()
+
+	return sortedKeys(st.tables)
+}
+
+// CheckTemplates returns an error for every entry that names a template that does not exist in the TemplateLibrary
+func (st *SpawnTables) CheckTemplates(templates *TemplateLibrary) error {
+	st.mutex.RLock()
+	defer st.mutex.RUnlock()
+
+	exists := make(map[string]bool)
+	for _, path := range templates.Paths() {
+		exists[path] = true
+	}
+
+	errs := ErrorList{}
+	for _, path := range sortedKeys(st.tables) {
+		for i, entry := range st.tables[path].Entries {
+			if entry.Template != "" && !exists[entry.Template] {
+				errs = append(errs, &DataError{File: st.origins[path], Path: path, Field: fmt.Sprintf("entries[%v].template", i), Err: fmt.Errorf("template %v does not exist", entry.Template)})
+			}
+		}
+	}
+
+	return errs.errorOrNil()
+}
+
+// Draw draws from the spawn table at the given path, for the given depth, and returns the paths of the templates to
+// spawn. The table is drawn from as many times as its Rolls. Each time, one of the entries allowed at the depth is
+// chosen by weight, and its Count is rolled: a template entry adds that many copies of its template, and a table entry
+// draws from the nested table that many times. If no entries are allowed at the depth, nothing is drawn. Only the rng is
+// used for randomness, so the same seed always draws the same templates.
+func (st *SpawnTables) Draw(path string, depth int, rng *randomnumbergenerator.RNG) ([]string, error) {
+	st.mutex.RLock()
+	defer st.mutex.RUnlock()
+
+	if _, ok := st.tables[path]; !ok {
+		return nil, fmt.Errorf("spawn table %v does not exist", path)
+	}
+
+	return st.draw(path, depth, rng), nil
+}
+
+// draw draws from a table that is known to exist. The SpawnTables lock must be held.
+func (st *SpawnTables) draw(path string, depth int, rng *randomnumbergenerator.RNG) []string {
+	table := st.tables[path]
+	templates := []string{}
+
+	entries := []SpawnEntry{}
+	weights := []int{}
+	for _, entry := range table.Entries {
+		if depth < entry.MinDepth || (entry.MaxDepth > 0 && depth > entry.MaxDepth) {
+			continue
+		}
+
+		entries = append(entries, entry)
+		weights = append(weights, entry.Weight)
+	}
+
+	for roll := table.Rolls.Roll(rng); roll > 0; roll-- {
+		index := rng.WeightedIndex(weights)
+		if index < 0 {
+			return templates
+		}
+
+		entry := entries[index]
+		for count := entry.Count.Roll(rng); count > 0; count-- {
+			if entry.Template != "" {
+				templates = append(templates, entry.Template)
+			} else {
+				templates = append(templates, st.draw(entry.Table, depth, rng)...)
+			}
+		}
+	}
+
+	return templates
+}
+
+// collectSpawnTables walks a block of loaded data, and parses every spawn table found, keyed by its path
+func collectSpawnTables(sourceName, prefix string, data map[string]interface{}, tables map[string]SpawnTable, origins map[string]string) ErrorList {
+	errs := ErrorList{}
+
+	for _, key := range sortedKeys(data) {
+		block, ok := data[key].(map[string]interface{})
+		if !ok || isDefinition(block) {
+			continue
+		}
+
+		path := key
+		if prefix != "" {
+			path = prefix + "." + key
+		}
+
+		if _, isTable := block[entriesKey]; !isTable {
+			errs = append(errs, collectSpawnTables(sourceName, path, block, tables, origins)...)
+			continue
+		}
+
+		if origin, ok := origins[path]; ok {
+			errs = append(errs, &DataError{File: sourceName, Path: path, Err: fmt.Errorf("spawn table is already defined in %v", origin)})
+			continue
+		}
+
+		table, tableErrs := parseSpawnTable(block)
+		if len(tableErrs) > 0 {
+			errs = append(errs, tableErrs.withLocation(sourceName, path)...)
+			continue
+		}
+
+		tables[path] = table
+		origins[path] = sourceName
+	}
+
+	return errs
+}
+
+// parseSpawnTable turns a block of loaded data into a SpawnTable
+func parseSpawnTable(block map[string]interface{}) (SpawnTable, ErrorList) {
+	table := SpawnTable{Rolls: randomnumbergenerator.Dice{Modifier: 1}}
+	errs := ErrorList{}
+
+	for _, key := range sortedKeys(block) {
+		if key != entriesKey && key != "rolls" {
+			errs = append(errs, &DataError{Field: key, Err: errors.New("unknown field")})
+		}
+	}
+
+	if rolls, ok := block["rolls"]; ok {
+		dice, err := decodeDice(rolls)
+		if err != nil {
+			errs = append(errs, &DataError{Field: "rolls", Err: err})
+		}
+		table.Rolls = dice.(randomnumbergenerator.Dice)
+	}
+
+	entries, ok := block[entriesKey].([]interface{})
+	if !ok {
+		errs = append(errs, &DataError{Field: entriesKey, Err: fmt.Errorf("expected a list, got %v", describeValue(block[entriesKey]))})
+		return table, errs
+	}
+
+	for i, item := range entries {
+		entry, entryErrs := parseSpawnEntry(item, fmt.Sprintf("entries[%v]", i))
+		errs = append(errs, entryErrs...)
+		table.Entries = append(table.Entries, entry)
+	}
+
+	return table, errs
+}
+
+// parseSpawnEntry turns a single entry of a spawn table into a SpawnEntry. path is the location of the entry within the
+// table, for errors.
+func parseSpawnEntry(item interface{}, path string) (SpawnEntry, ErrorList) {
+	entry := SpawnEntry{Weight: 1, Count: randomnumbergenerator.Dice{Modifier: 1}}
+	errs := ErrorList{}
+
+	values, ok := item.(map[string]interface{})
+	if !ok {
+		errs = append(errs, &DataError{Field: path, Err: fmt.Errorf("expected an object, got %v", describeValue(item))})
+		return entry, errs
+	}
+
+	for _, key := range sortedKeys(values) {
+		value := values[key]
+		field := joinFieldPath(path, key)
+
+		var err error
+		switch key {
+		case "template", "table":
+			text, isString := value.(string)
+			if !isString || text == "" {
+				err = fmt.Errorf("expected a path, got %v", describeValue(value))
+			} else if key == "template" {
+				entry.Template = text
+			} else {
+				entry.Table = text
+			}
+		case "weight", "min_depth", "max_depth":
+			var number float64
+			number, err = wholeNumber(value)
+			if err == nil && key == "weight" && number < 0 {
+				err = fmt.Errorf("weight cannot be negative, got %v", number)
+			}
+
+			switch key {
+			case "weight":
+				entry.Weight = int(number)
+			case "min_depth":
+				entry.MinDepth = int(number)
+			case "max_depth":
+				entry.MaxDepth = int(number)
+			}
+		case "count":
+			var dice interface{}
+			dice, err = decodeDice(value)
+			entry.Count = dice.(randomnumbergenerator.Dice)
+		default:
+			err = errors.New("unknown field")
+		}
+
+		if err != nil {
+			errs = append(errs, &DataError{Field: field, Err: err})
+		}
+	}
+
+	if (entry.Template == "") == (entry.Table == "") {
+		errs = append(errs, &DataError{Field: path, Err: errors.New("an entry must name either a template or a table")})
+	}
+
+	return entry, errs
+}
+
+// checkSpawnCycle returns an error if the table at path includes itself, directly or through other tables. Tables that
+// have already been checked are recorded in checked, and chain holds the tables currently being checked.
+func checkSpawnCycle(path string, tables map[string]SpawnTable, checked map[string]bool, chain []string) *DataError {
+	if checked[path] {
+		return nil
+	}
+
+	for i, link := range chain {
+		if link == path {
+			cycle := append(append([]string{}, chain[i:]...), path)
+			return &DataError{Path: path, Err: fmt.Errorf("spawn table cycle: %v", strings.Join(cycle, " -> "))}
+		}
+	}
+	chain = append(chain, path)
+
+	for _, entry := range tables[path].Entries {
+		if entry.Table == "" {
+			continue
+		}
+
+		if err := checkSpawnCycle(entry.Table, tables, checked, chain); err != nil {
+			return err
+		}
+	}
+
+	checked[path] = true
+	return nil
+}
+
+// decodeDice decodes a randomnumbergenerator.Dice from a dice expression (ie "2d6+1"), or a plain number
+func decodeDice(value interface{}) (interface{}, error) {
+	switch typed := value.(type) {
+	case string:
+		return randomnumbergenerator.ParseDice(typed)
+	case float64:
+		number, err := wholeNumber(typed)
+		return randomnumbergenerator.Dice{Modifier: int(number)}, err
+	default:
+		return randomnumbergenerator.Dice{}, fmt.Errorf("expected a dice expression, got %v", describeValue(value))
+	}
+}
+
+// LoadSpawnTables loads every data file in the FileLoaders location, and returns the spawn tables found in them. Every
+// file that fails to load, and every problem with the tables, is returned together in an ErrorList. The SpawnTables are
+// only returned if there were no errors.
+func (fl *FileLoader) LoadSpawnTables() (*SpawnTables, error) {
+	errs := ErrorList{}
+
+	sources, err := fl.LoadAllFromFiles()
+	if loadErrs, ok := err.(ErrorList); ok {
+		errs = append(errs, loadErrs...)
+	} else if err != nil {
+		return nil, err
+	}
+
+	spawnTables := NewSpawnTables()
+	err = spawnTables.Load(sources)
+	if tableErrs, ok := err.(ErrorList); ok {
+		errs = append(errs, tableErrs...)
+	} else if err != nil {
+		errs = append(errs, err)
+	}
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	return spawnTables, nil
+}
+
+// SetPositionComponent sets the name of the component SpawnFromTable uses to place spawned entities ("position" by
+// default). The component must have X and Y fields (or fields renamed to X and Y, see structFields).
+func (el *EntityLoader) SetPositionComponent(componentName string) {
+	el.mutex.Lock()
+	defer el.mutex.Unlock()
+
+	el.positionComponent = componentName
+}
+
+// SpawnFromTable draws from a spawn table (see SpawnTables.Draw), and creates an entity from each template drawn, with
+// CreateFromTemplate. Each entity is placed on its own floor tile of the map, chosen at random from the tiles in
+// surface.FloorTiles that are not blocked, by setting the X and Y of its position component (see
+// SetPositionComponent). The IDs of the entities created are returned. An entity that cannot be created does not stop
+// the rest from being spawned, every problem is returned together as an ErrorList. If there are more entities than free
+// floor tiles, the extra entities are not spawned, and an error is returned.
+func (el *EntityLoader) SpawnFromTable(tables *SpawnTables, path string, depth int, surface *gamemap.GameMap, rng *randomnumbergenerator.RNG) ([]int, error) {
+	templates, err := tables.Draw(path, depth, rng)
+	if err != nil {
+		return nil, err
+	}
+
+	el.mutex.Lock()
+	positionComponent := el.positionComponent
+	el.mutex.Unlock()
+
+	freeTiles := []*gamemap.Tile{}
+	for _, tile := range surface.FloorTiles {
+		if !surface.IsBlocked(tile.X, tile.Y) {
+			freeTiles = append(freeTiles, tile)
+		}
+	}
+
+	entities := []int{}
+	errs := ErrorList{}
+
+	for i, template := range templates {
+		if len(freeTiles) == 0 {
+			errs = append(errs, fmt.Errorf("no free floor tiles left, %v of %v entities were not spawned", len(templates)-i, len(templates)))
+			break
+		}
+
+		// Take the tile out of the list, so no two entities are placed on the same tile
+		index := rng.Range(0, len(freeTiles))
+		tile := freeTiles[index]
+		freeTiles[index] = freeTiles[len(freeTiles)-1]
+		freeTiles = freeTiles[:len(freeTiles)-1]
+
+		entity, err := el.CreateFromTemplate(template, map[string]interface{}{
+			positionComponent: map[string]interface{}{"X": float64(tile.X), "Y": float64(tile.Y)},
+		})
+		if createErrs, ok := err.(ErrorList); ok {
+			errs = append(errs, createErrs...)
+			continue
+		} else if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		entities = append(entities, entity)
+	}
+
+	return entities, errs.errorOrNil()
+}
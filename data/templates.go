@@ -0,0 +1,301 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// inheritsKey is the key in an entity definition that names the template(s) the definition inherits from
+const inheritsKey = "inherits"
+
+// errUnresolved is returned when resolving a template that has already failed to resolve, and been reported
+var errUnresolved = errors.New("template could not be resolved")
+
+// TemplateLibrary holds entity definitions (templates), addressed by a dotted path made up of the keys leading to the
+// definition, ie the definition of "large_rat" under "level_1" has the path "level_1.large_rat".
+// A definition can inherit from one or more other definitions, using an "inherits" key, whose value is either a single
+// path, or a list of paths. Parents are looked up relative to the definitions own group first (so a definition under
+// "level_1" can simply inherit from "base_rat"), and then as a full path. Inheritance can span as many levels as
+// needed. The definition is merged on top of its parents, field by field: nested maps (such as "components", and the
+// values of each component) are merged, while any other value replaces the inherited one. A null value removes the
+// inherited value entirely. When inheriting from several parents, later parents override earlier ones.
+// Example:
+// "base_rat": {"components": {"position": {}, "appearance": {"Glyph": {"Char": "r", "Color": "brown"}, "Layer": 1}}},
+// "large_rat": {"inherits": "base_rat", "components": {"appearance": {"Name": "Large rat"}}}
+type TemplateLibrary struct {
+	templates map[string]map[string]interface{}
+	origins   map[string]string
+	mutex     sync.RWMutex
+}
+
+// NewTemplateLibrary creates a new, empty, TemplateLibrary
+func NewTemplateLibrary() *TemplateLibrary {
+	templateLibrary := TemplateLibrary{}
+	templateLibrary.templates = make(map[string]map[string]interface{})
+	templateLibrary.origins = make(map[string]string)
+
+	return &templateLibrary
+}
+
+// Load resolves all the definitions found in the given data, as returned from FileLoader.LoadAllFromFiles (or a single
+// file, keyed by its name). A map is treated as a definition if it has a "components" or "inherits" key, otherwise it is
+// treated as a group of definitions. Every definitions inheritance is resolved and validated: it is an error if a
+// parent does not exist, if definitions inherit from each other in a cycle, or if the same path is defined more than
+// once. Every problem found is returned, as an ErrorList of DataErrors. Load replaces any templates loaded previously,
+// but leaves the library untouched if an error is returned.
+func (tl *TemplateLibrary) Load(sources map[string]map[string]interface{}) error {
+	definitions := make(map[string]map[string]interface{})
+	origins := make(map[string]string)
+
+	// Go through the sources in order, so that errors are reported consistently
+	sourceNames := []string{}
+	for sourceName := range sources {
+		sourceNames = append(sourceNames, sourceName)
+	}
+	sort.Strings(sourceNames)
+
+	errs := ErrorList{}
+	for _, sourceName := range sourceNames {
+		errs = append(errs, collectDefinitions(sourceName, "", sources[sourceName], definitions, origins)...)
+	}
+
+	resolved := make(map[string]map[string]interface{})
+	failed := make(map[string]bool)
+	for _, path := range sortedKeys(definitions) {
+		_, err := resolveTemplate(path, definitions, resolved, failed, []string{})
+		if err != nil && err != errUnresolved {
+			err.(*DataError).File = origins[err.(*DataError).Path]
+			errs = append(errs, err)
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	tl.mutex.Lock()
+	tl.templates = resolved
+	tl.origins = origins
+	tl.mutex.Unlock()
+
+	return nil
+}
+
+// Template returns the fully resolved definition for the template at the given path, with all inherited values merged
+// in. The returned map is a copy, and can be safely modified. The second return value is false if there is no template
+// at that path.
+func (tl *TemplateLibrary) Template(path string) (map[string]interface{}, bool) {
+	tl.mutex.RLock()
+	defer tl.mutex.RUnlock()
+
+	template, ok := tl.templates[path]
+	if !ok {
+		return nil, false
+	}
+
+	return copyValue(template).(map[string]interface{}), true
+}
+
+// Paths returns the paths of every template in the library, in alphabetical order
+func (tl *TemplateLibrary) Paths() []string {
+	tl.mutex.RLock()
+	defer tl.mutex.RUnlock()
+
+	return sortedKeys(tl.templates)
+}
+
+// isDefinition returns true if a block of data is an entity definition, rather than a group of definitions
+func isDefinition(block map[string]interface{}) bool {
+	_, hasComponents := block["components"]
+	_, hasInherits := block[inheritsKey]
+
+	return hasComponents || hasInherits
+}
+
+// source returns the name of the source (file) the template at path was loaded from
+func (tl *TemplateLibrary) source(path string) string {
+	tl.mutex.RLock()
+	defer tl.mutex.RUnlock()
+
+	return tl.origins[path]
+}
+
+// collectDefinitions walks a block of loaded data, and records every definition found, keyed by its path. It returns an
+// error for every definition that has already been recorded from another source.
+func collectDefinitions(sourceName, prefix string, data map[string]interface{}, definitions map[string]map[string]interface{}, origins map[string]string) ErrorList {
+	errs := ErrorList{}
+
+	for _, key := range sortedKeys(data) {
+		block, ok := data[key].(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		path := key
+		if prefix != "" {
+			path = prefix + "." + key
+		}
+
+		if !isDefinition(block) {
+			// Not a definition, so this must be a group of definitions
+			errs = append(errs, collectDefinitions(sourceName, path, block, definitions, origins)...)
+			continue
+		}
+
+		if origin, ok := origins[path]; ok {
+			errs = append(errs, &DataError{File: sourceName, Path: path, Err: fmt.Errorf("template is already defined in %v", origin)})
+			continue
+		}
+
+		definitions[path] = block
+		origins[path] = sourceName
+	}
+
+	return errs
+}
+
+// resolveTemplate returns the definition at path, merged on top of everything it inherits from. Resolved definitions
+// are cached in resolved, and the paths of definitions that could not be resolved are recorded in failed, so each
+// problem is only reported once. chain holds the paths currently being resolved, and is used to detect cycles.
+func resolveTemplate(path string, definitions, resolved map[string]map[string]interface{}, failed map[string]bool, chain []string) (map[string]interface{}, error) {
+	if template, ok := resolved[path]; ok {
+		return template, nil
+	}
+
+	if failed[path] {
+		return nil, errUnresolved
+	}
+
+	for i, link := range chain {
+		if link == path {
+			cycle := append(append([]string{}, chain[i:]...), path)
+			return nil, &DataError{Path: path, Err: fmt.Errorf("inheritance cycle: %v", strings.Join(cycle, " -> "))}
+		}
+	}
+	chain = append(chain, path)
+
+	definition := definitions[path]
+
+	parents, err := parentPaths(definition[inheritsKey], path, definitions)
+	if err != nil {
+		failed[path] = true
+		return nil, &DataError{Path: path, Err: err}
+	}
+
+	template := make(map[string]interface{})
+	for _, parent := range parents {
+		parentTemplate, err := resolveTemplate(parent, definitions, resolved, failed, chain)
+		if err != nil {
+			failed[path] = true
+			return nil, err
+		}
+
+		template = mergeValues(template, parentTemplate)
+	}
+
+	child := copyValue(definition).(map[string]interface{})
+	delete(child, inheritsKey)
+	template = mergeValues(template, child)
+
+	resolved[path] = template
+	return template, nil
+}
+
+// parentPaths returns the full paths of the templates a definition inherits from. A parent is looked up in the
+// definitions own group first, and then as a full path.
+func parentPaths(inherits interface{}, path string, definitions map[string]map[string]interface{}) ([]string, error) {
+	names := []string{}
+
+	switch value := inherits.(type) {
+	case nil:
+		return names, nil
+	case string:
+		names = append(names, value)
+	case []interface{}:
+		for _, name := range value {
+			nameString, ok := name.(string)
+			if !ok {
+				return nil, errors.New("inherits must be a template path, or a list of template paths")
+			}
+			names = append(names, nameString)
+		}
+	default:
+		return nil, errors.New("inherits must be a template path, or a list of template paths")
+	}
+
+	group := ""
+	if index := strings.LastIndex(path, "."); index >= 0 {
+		group = path[:index+1]
+	}
+
+	parents := []string{}
+	for _, name := range names {
+		if _, ok := definitions[group+name]; ok {
+			parents = append(parents, group+name)
+		} else if _, ok := definitions[name]; ok {
+			parents = append(parents, name)
+		} else {
+			return nil, fmt.Errorf("inherits from %v, which does not exist", name)
+		}
+	}
+
+	return parents, nil
+}
+
+// mergeValues merges override on top of base, and returns the result. Nested maps are merged, any other value in
+// override replaces the one in base, and a nil value removes the key. Neither map is modified.
+func mergeValues(base, override map[string]interface{}) map[string]interface{} {
+	merged := copyValue(base).(map[string]interface{})
+
+	for key, value := range override {
+		if value == nil {
+			delete(merged, key)
+			continue
+		}
+
+		baseMap, baseIsMap := merged[key].(map[string]interface{})
+		overrideMap, overrideIsMap := value.(map[string]interface{})
+
+		if baseIsMap && overrideIsMap {
+			merged[key] = mergeValues(baseMap, overrideMap)
+		} else {
+			merged[key] = copyValue(value)
+		}
+	}
+
+	return merged
+}
+
+// copyValue returns a deep copy of a value loaded from a data file
+func copyValue(value interface{}) interface{} {
+	switch typed := value.(type) {
+	case map[string]interface{}:
+		valueCopy := make(map[string]interface{}, len(typed))
+		for key, item := range typed {
+			valueCopy[key] = copyValue(item)
+		}
+		return valueCopy
+	case []interface{}:
+		valueCopy := make([]interface{}, len(typed))
+		for i, item := range typed {
+			valueCopy[i] = copyValue(item)
+		}
+		return valueCopy
+	default:
+		return value
+	}
+}
+
+// sortedKeys returns the keys of a map, in alphabetical order
+func sortedKeys[V any](values map[string]V) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
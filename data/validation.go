@@ -0,0 +1,62 @@
+package data
+
+import (
+	"github.com/gogue-framework/gogue/ecs"
+)
+
+// LoadTemplates loads every data file in the FileLoaders location into a new TemplateLibrary. Every file that fails to
+// load, and every problem with the templates themselves (missing parents, cycles, and duplicates), is returned together
+// in an ErrorList. The TemplateLibrary is only returned if there were no errors.
+func (fl *FileLoader) LoadTemplates() (*TemplateLibrary, error) {
+	errs := ErrorList{}
+
+	sources, err := fl.LoadAllFromFiles()
+	if loadErrs, ok := err.(ErrorList); ok {
+		errs = append(errs, loadErrs...)
+	} else if err != nil {
+		return nil, err
+	}
+
+	library := NewTemplateLibrary()
+	err = library.Load(sources)
+	if templateErrs, ok := err.(ErrorList); ok {
+		errs = append(errs, templateErrs...)
+	} else if err != nil {
+		errs = append(errs, err)
+	}
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	return library, nil
+}
+
+// Validate checks every definition in the FileLoaders data files against the components mapped on the controller,
+// without creating any entities. Along with the problems reported by LoadTemplates, it reports components that have
+// not been mapped, fields that do not exist on their component, and values of the wrong type. Each problem is returned
+// as a DataError, naming the file, definition, component, and field it was found in, collected together in an
+// ErrorList. This makes it easy to check data files as part of a games tests:
+// err := fileLoader.Validate(controller)
+func (fl *FileLoader) Validate(controller *ecs.Controller) error {
+	return fl.ValidateWith(NewEntityLoader(controller))
+}
+
+// ValidateWith works the same way as Validate, but uses the given EntityLoader (and any decoders registered on it) to
+// check the definitions.
+func (fl *FileLoader) ValidateWith(entityLoader *EntityLoader) error {
+	library, err := fl.LoadTemplates()
+	if err != nil {
+		return err
+	}
+
+	errs := ErrorList{}
+	for _, path := range library.Paths() {
+		template, _ := library.Template(path)
+
+		_, definitionErrs := entityLoader.buildComponents(template)
+		errs = append(errs, definitionErrs.withLocation(library.source(path), path)...)
+	}
+
+	return errs.errorOrNil()
+}
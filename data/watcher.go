@@ -0,0 +1,177 @@
+package data
+
+import (
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ReloadEvent is sent to a Watchers subscribers when data files have changed. Changed holds the paths of every file that
+// was added, modified, or removed since the last time the Watcher checked. Data holds the freshly loaded contents of
+// every data file, in the same form as FileLoader.LoadAllFromFiles returns, and Err holds any errors from loading them.
+// Data only contains the files that loaded successfully, so subscribers should usually ignore events with an Err.
+type ReloadEvent struct {
+	Changed []string
+	Data    map[string]map[string]interface{}
+	Err     error
+}
+
+// watchedFile identifies a file in one of a FileLoaders layers
+type watchedFile struct {
+	layer int
+	name  string
+}
+
+// fileState is what a Watcher remembers about a file, to tell if it has changed
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+// Watcher watches the data files of a FileLoader for changes, so that data can be reloaded while the game is running,
+// which is handy during development. It works by polling: each call to Poll checks the modification time and size of
+// every data file, and if anything has changed, every file is reloaded, and subscribers are notified. Poll can be
+// called from the game loop (which keeps everything on one goroutine), or the Watcher can poll in the background with
+// Start, in which case subscribers are called from the Watchers goroutine.
+type Watcher struct {
+	fileLoader  *FileLoader
+	files       map[watchedFile]fileState
+	subscribers []func(event ReloadEvent)
+	stop        chan struct{}
+	done        sync.WaitGroup
+	mutex       sync.Mutex
+}
+
+// NewWatcher creates a Watcher for the FileLoaders data files. The current state of the files is recorded straight away,
+// so only changes made after the Watcher is created are reported.
+func (fl *FileLoader) NewWatcher() *Watcher {
+	watcher := Watcher{}
+	watcher.fileLoader = fl
+	watcher.files = fl.fileStates()
+
+	return &watcher
+}
+
+// Subscribe registers a function to be called whenever data files have changed, and have been reloaded. Subscribers are
+// called in the order they subscribed. A subscriber must not call Poll, Start, or Stop.
+func (w *Watcher) Subscribe(subscriber func(event ReloadEvent)) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	w.subscribers = append(w.subscribers, subscriber)
+}
+
+// Poll checks the data files for changes. If any file has been added, modified, or removed, all data files are
+// reloaded, and every subscriber is notified. Poll returns true if anything changed.
+func (w *Watcher) Poll() bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	files := w.fileLoader.fileStates()
+	changed := []string{}
+
+	for file, state := range files {
+		if previous, ok := w.files[file]; !ok || previous != state {
+			changed = append(changed, file.name)
+		}
+	}
+
+	for file := range w.files {
+		if _, ok := files[file]; !ok {
+			changed = append(changed, file.name)
+		}
+	}
+
+	w.files = files
+
+	if len(changed) == 0 {
+		return false
+	}
+
+	sort.Strings(changed)
+
+	data, err := w.fileLoader.LoadAllFromFiles()
+	event := ReloadEvent{Changed: changed, Data: data, Err: err}
+
+	for _, subscriber := range w.subscribers {
+		subscriber(event)
+	}
+
+	return true
+}
+
+// Start polls for changes in the background, every interval, until Stop is called. Calling Start on a Watcher that has
+// already been started does nothing.
+func (w *Watcher) Start(interval time.Duration) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.stop != nil {
+		return
+	}
+
+	stop := make(chan struct{})
+	w.stop = stop
+	w.done.Add(1)
+
+	go func() {
+		defer w.done.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				w.Poll()
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops polling in the background, and waits for any poll in progress to finish
+func (w *Watcher) Stop() {
+	w.mutex.Lock()
+	stop := w.stop
+	w.stop = nil
+	w.mutex.Unlock()
+
+	if stop == nil {
+		return
+	}
+
+	close(stop)
+	w.done.Wait()
+}
+
+// fileStates returns the state of every data file the FileLoader would load, from every layer
+func (fl *FileLoader) fileStates() map[watchedFile]fileState {
+	files := make(map[watchedFile]fileState)
+
+	for i, layer := range fl.layers {
+		fs.WalkDir(layer.fsys, ".", func(filePath string, entry fs.DirEntry, err error) error {
+			if err != nil || entry.IsDir() {
+				return nil
+			}
+
+			if _, ok := fl.formatFor(filePath); !ok {
+				return nil
+			}
+
+			info, err := entry.Info()
+			if err != nil {
+				return nil
+			}
+
+			file := watchedFile{layer: i, name: filepath.Join(layer.name, filepath.FromSlash(filePath))}
+			files[file] = fileState{modTime: info.ModTime(), size: info.Size()}
+			return nil
+		})
+	}
+
+	return files
+}
@@ -0,0 +1,200 @@
+package ecs
+
+import (
+	"reflect"
+	"sync"
+)
+
+// FlushMode determines when the Controller applies the commands recorded in its CommandBuffer during Process
+type FlushMode int
+
+const (
+	// FlushAfterFrame applies recorded commands once, after every system has been processed
+	FlushAfterFrame FlushMode = iota
+	// FlushAfterPriorityGroup applies recorded commands after each group of systems sharing a priority has been
+	// processed, so later priority groups see the changes made by earlier ones
+	FlushAfterPriorityGroup
+)
+
+// commandType identifies the structural change a command represents
+type commandType int
+
+const (
+	createEntityCommand commandType = iota
+	deleteEntityCommand
+	addComponentCommand
+	removeComponentCommand
+)
+
+// command is a single recorded structural change. Commands that target an existing entity hold a handle to it, rather
+// than a bare ID, so that a command recorded against an entity that is deleted before the buffer is flushed can be
+// safely discarded.
+type command struct {
+	commandType   commandType
+	handle        EntityHandle
+	components    []Component
+	componentType reflect.Type
+}
+
+// CommandBuffer records structural changes to the ECS (creating and deleting entities, and adding and removing
+// components) so they can be applied later, at a well defined point. Adding or removing entities and components while
+// another system is iterating over them changes the underlying data mid-loop, which leads to skipped or repeated
+// entities. Systems should instead record these changes on the Controllers CommandBuffer (via Controller.Commands())
+// during Process, and the Controller will apply them between systems, as determined by its FlushMode. Commands are
+// applied in the order they were recorded. Recording commands is safe from multiple goroutines.
+type CommandBuffer struct {
+	controller *Controller
+	commands   []command
+	routes     map[uint64]*CommandBuffer
+	mutex      sync.Mutex
+}
+
+// newCommandBuffer creates an empty CommandBuffer, which records commands against the given controller
+func newCommandBuffer(controller *Controller) *CommandBuffer {
+	commandBuffer := CommandBuffer{}
+	commandBuffer.controller = controller
+	commandBuffer.commands = []command{}
+
+	return &commandBuffer
+}
+
+// CreateEntity records the creation of a new entity, with the given components attached
+func (cb *CommandBuffer) CreateEntity(components []Component) {
+	cb.record(command{commandType: createEntityCommand, components: components})
+}
+
+// DeleteEntity records the deletion of an entity
+func (cb *CommandBuffer) DeleteEntity(entity int) {
+	cb.record(command{commandType: deleteEntityCommand, handle: cb.controller.GetHandle(entity)})
+}
+
+// AddComponent records a component being attached to an entity. If the entity has been deleted by the time the command
+// is applied, it is discarded.
+func (cb *CommandBuffer) AddComponent(entity int, component Component) {
+	cb.record(command{commandType: addComponentCommand, handle: cb.controller.GetHandle(entity), components: []Component{component}})
+}
+
+// RemoveComponent records a component being removed from an entity. If the entity has been deleted by the time the
+// command is applied, it is discarded.
+func (cb *CommandBuffer) RemoveComponent(entity int, componentType reflect.Type) {
+	cb.record(command{commandType: removeComponentCommand, handle: cb.controller.GetHandle(entity), componentType: componentType})
+}
+
+// Len returns the number of commands waiting to be applied
+func (cb *CommandBuffer) Len() int {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	return len(cb.commands)
+}
+
+// record appends a command to the buffer. If the command is being recorded by a system that is being processed in
+// parallel, it is appended to the systems own buffer instead (see route).
+func (cb *CommandBuffer) record(newCommand command) {
+	cb.mutex.Lock()
+
+	if len(cb.routes) > 0 {
+		if target, ok := cb.routes[goroutineID()]; ok {
+			cb.mutex.Unlock()
+			target.record(newCommand)
+			return
+		}
+	}
+
+	cb.commands = append(cb.commands, newCommand)
+	cb.mutex.Unlock()
+}
+
+// appendFrom moves every command recorded on another buffer to the end of this one
+func (cb *CommandBuffer) appendFrom(other *CommandBuffer) {
+	commands := other.take()
+	if len(commands) == 0 {
+		return
+	}
+
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	cb.commands = append(cb.commands, commands...)
+}
+
+// route sends every command recorded on this buffer by the given goroutine to another buffer, until it is routed to nil.
+// This lets systems processed in parallel keep using the controllers CommandBuffer, while their commands are still
+// applied in processing order.
+func (cb *CommandBuffer) route(goroutine uint64, target *CommandBuffer) {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	if target == nil {
+		delete(cb.routes, goroutine)
+		return
+	}
+
+	if cb.routes == nil {
+		cb.routes = make(map[uint64]*CommandBuffer)
+	}
+	cb.routes[goroutine] = target
+}
+
+// take removes and returns every command currently in the buffer
+func (cb *CommandBuffer) take() []command {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	commands := cb.commands
+	cb.commands = []command{}
+
+	return commands
+}
+
+// flush applies every recorded command to the controller, in the order they were recorded. Observers triggered by
+// applying a command may record further commands, so the buffer is drained until it is empty.
+func (cb *CommandBuffer) flush() {
+	for commands := cb.take(); len(commands) > 0; commands = cb.take() {
+		for _, recorded := range commands {
+			cb.apply(recorded)
+		}
+	}
+}
+
+// apply carries out a single command. Commands targeting an entity that no longer exists are ignored.
+func (cb *CommandBuffer) apply(recorded command) {
+	c := cb.controller
+
+	switch recorded.commandType {
+	case createEntityCommand:
+		c.CreateEntity(recorded.components)
+	case deleteEntityCommand:
+		if c.IsAlive(recorded.handle) {
+			c.DeleteEntity(recorded.handle.ID)
+		}
+	case addComponentCommand:
+		if c.IsAlive(recorded.handle) {
+			c.AddComponent(recorded.handle.ID, recorded.components[0])
+		}
+	case removeComponentCommand:
+		if c.IsAlive(recorded.handle) {
+			c.RemoveComponent(recorded.handle.ID, recorded.componentType)
+		}
+	}
+}
+
+// Commands returns the controllers CommandBuffer. Systems should use it to record structural changes during Process,
+// rather than modifying the controller directly. It is safe to use from systems that are processed in parallel (see
+// SetWorkers).
+func (c *Controller) Commands() *CommandBuffer {
+	return c.commands
+}
+
+// SetFlushMode sets when commands recorded on the controllers CommandBuffer are applied during Process. The default is
+// FlushAfterFrame.
+func (c *Controller) SetFlushMode(mode FlushMode) {
+	c.flushMode = mode
+}
+
+// FlushCommands immediately applies every command recorded on the controllers CommandBuffer. Process and ProcessSystem
+// call this automatically, but it can be useful when making changes from outside of a system (for example, the game
+// loop, or a screen).
+func (c *Controller) FlushCommands() {
+	c.commands.flush()
+}
@@ -1,9 +1,5 @@
 package ecs
 
-import (
-	"reflect"
-)
-
 // Component is a metadata container used to house information about something an entities state.
 // Example Components might look like:
 // type PositionComponent struct {
@@ -17,6 +13,9 @@ import (
 // CanAttackComponent has no data attached, and acts merely as a flag. If an entity has this component, they can attack
 // if an entity is missing this component, they cannot attack.
 // Components are a flexible way of attaching metadata to an entity.
-type Component interface {
-	TypeOf() reflect.Type
-}
+// Any type can be used as a component. Components are stored under their own type (as returned by reflect.TypeOf), and
+// the generic helpers (see Get) derive the type from their type parameter, so components do not need any methods. The
+// reflection based API takes the type of the component, which ComponentType provides (ie
+// ecs.ComponentType[PositionComponent]()). Components written for earlier versions of Gogue have a TypeOf method
+// returning the same type, which keeps working.
+type Component interface{}
@@ -3,20 +3,34 @@ package ecs
 import (
 	"fmt"
 	"reflect"
-	"sort"
+	"sync"
 )
 
 // Controller acts as a central coordinator for all entities within the game. In most cases, a single controller will
 // suffice for an entire game. The controller is responsible for creating new entities, managing components attached to
 // those entities, and registering and coordinating systems that act upon those entities.
+// Entity and component access (creating and deleting entities, adding, updating, getting and removing components, and
+// queries) is safe to use from systems running in parallel. Registering components, systems and observers is not, and
+// should be done while setting up the game.
 type Controller struct {
-	systems       map[reflect.Type]System
-	sortedSystems map[int][]System
-	priorityKeys  []int
-	nextEntityID  int
-	components    map[reflect.Type][]int
-	entities      map[int]map[reflect.Type]Component
-	deadEntities  []int
+	systems         map[string]*systemEntry
+	sortedSystems   map[int][]*systemEntry
+	priorityKeys    []int
+	nextSystemOrder int
+	disabledGroups  map[string]bool
+	nextEntityID    int
+	components      map[reflect.Type][]int
+	entities        map[int]map[reflect.Type]Component
+	deadEntities    []int
+	generations     []int
+	views           []*queryView
+	observers       observers
+	commands        *CommandBuffer
+	flushMode       FlushMode
+	events          *EventBus
+	tick            int
+	workers         int
+	mutex           sync.RWMutex
 
 	// The component map will keep track of what components are available
 	componentMap map[string]Component
@@ -25,47 +39,81 @@ type Controller struct {
 // NewController is a convenience/constructor method to properly initialize a new processor
 func NewController() *Controller {
 	controller := Controller{}
-	controller.systems = make(map[reflect.Type]System)
-	controller.sortedSystems = make(map[int][]System)
+	controller.systems = make(map[string]*systemEntry)
+	controller.sortedSystems = make(map[int][]*systemEntry)
 	controller.priorityKeys = []int{}
+	controller.nextSystemOrder = 0
+	controller.disabledGroups = make(map[string]bool)
 	controller.nextEntityID = 0
 	controller.components = make(map[reflect.Type][]int)
 	controller.entities = make(map[int]map[reflect.Type]Component)
 	controller.deadEntities = []int{}
+	controller.generations = []int{}
+	controller.views = []*queryView{}
+	controller.observers = newObservers()
+	controller.commands = newCommandBuffer(&controller)
+	controller.flushMode = FlushAfterFrame
+	controller.events = NewEventBus()
+	controller.workers = 1
 	controller.componentMap = make(map[string]Component)
 
 	return &controller
 }
 
-// CreateEntity creates a new entity in the world. An entity is simply a unique integer.
+// CreateEntity creates a new entity in the world. An entity is simply a unique integer. IDs of deleted entities are
+// re-used, so use GetHandle and IsAlive if a reference to the entity needs to be held on to long term.
 // If any components are provided, they will be associated with the created entity
 func (c *Controller) CreateEntity(components []Component) int {
-	entity := c.nextEntityID
+	c.mutex.Lock()
+	entity := c.nextFreeEntity()
 
-	c.entities[c.nextEntityID] = make(map[reflect.Type]Component)
+	c.entities[entity] = make(map[reflect.Type]Component)
+	c.updateViews(entity)
+	c.mutex.Unlock()
 
 	if len(components) > 0 {
 		for _, v := range components {
-			c.AddComponent(c.nextEntityID, v)
+			c.AddComponent(entity, v)
 		}
 	}
 
-	c.nextEntityID++
-
 	return entity
 }
 
 // DeleteEntity removes an entity, all component instances attached to that entity, and any components associations with
-// that entity
+// that entity. The ID of the deleted entity will be re-used for a future entity, and any handles to it are invalidated.
 func (c *Controller) DeleteEntity(entity int) {
-	// First, delete all the component associations for the entity to be removed
-	for k := range c.entities[entity] {
-		c.RemoveComponent(entity, k)
+	// Remove every component, and the entity itself, in one go, so that no other goroutine can add a component to the
+	// entity part way through
+	c.mutex.Lock()
+	components, ok := c.entities[entity]
+	if !ok {
+		c.mutex.Unlock()
+		return
+	}
+
+	componentTypes := sortedComponentTypes(components)
+	removed := make([]Component, len(componentTypes))
+	for i, componentType := range componentTypes {
+		removed[i] = components[componentType]
+		c.removeComponentLocked(entity, componentType)
 	}
 
-	// Then, delete the entity itself. The components have already been removed and disassociated with it, so a simple
-	// delete will do here
 	delete(c.entities, entity)
+	c.updateViews(entity)
+	c.mutex.Unlock()
+
+	// Observers are called once the lock has been released, so they are free to use the controller
+	for i, componentType := range componentTypes {
+		c.notifyRemove(entity, componentType, removed[i])
+	}
+
+	c.notifyEntityDeleted(entity)
+
+	// Finally, free up the ID for re-use
+	c.mutex.Lock()
+	c.freeEntity(entity)
+	c.mutex.Unlock()
 }
 
 // MapComponentClass registers a component with the controller. This map of components gives the controller access to the
@@ -99,13 +147,21 @@ func (c *Controller) HasMappedComponent(componentName string) bool {
 
 // AddComponent adds a component to an entity. The component is added to the global list of components for the
 // processor, and also directly associated with the entity itself. This allows for flexible checking of components,
-// as you can check which entites are associated with a component, and vice versa.
+// as you can check which entites are associated with a component, and vice versa. If the entity already has a
+// component of the same type, it is replaced, and any OnUpdate observers are notified. Otherwise, any OnAdd observers
+// are notified.
 func (c *Controller) AddComponent(entity int, component Component) {
 	// First, get the type of the component
 	componentType := reflect.TypeOf(component)
 
-	// Record that the component type is associated with the entity.
-	c.components[componentType] = append(c.components[componentType], entity)
+	c.mutex.Lock()
+	previous, replacing := c.entities[entity][componentType]
+
+	// Record that the component type is associated with the entity. If the entity already has a component of this
+	// type, the association already exists, and the component instance is simply replaced below.
+	if !replacing {
+		c.components[componentType] = append(c.components[componentType], entity)
+	}
 
 	// Now, check to see if the entity is already tracked in the controller entity list. If it is not, add it, and
 	// associate the component with it
@@ -114,10 +170,21 @@ func (c *Controller) AddComponent(entity int, component Component) {
 	}
 
 	c.entities[entity][componentType] = component
+	c.updateViews(entity)
+	c.mutex.Unlock()
+
+	if replacing {
+		c.notifyUpdate(entity, componentType, previous, component)
+	} else {
+		c.notifyAdd(entity, componentType, component)
+	}
 }
 
 // HasComponent checks a given entity to see if it has a given component associated with it
 func (c *Controller) HasComponent(entity int, componentType reflect.Type) bool {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
 	if _, ok := c.entities[entity][componentType]; ok {
 		return true
 	}
@@ -127,16 +194,23 @@ func (c *Controller) HasComponent(entity int, componentType reflect.Type) bool {
 
 // GetComponent returns the component instance for a component type, if one exists for the provided entity
 func (c *Controller) GetComponent(entity int, componentType reflect.Type) Component {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
 	// Check the given entity has the provided component
-	if c.HasComponent(entity, componentType) {
-		return c.entities[entity][componentType]
+	if component, ok := c.entities[entity][componentType]; ok {
+		return component
 	}
 
 	return nil
 }
 
-// GetEntity gets a specific entity, and all of its component instances
+// GetEntity gets a specific entity, and all of its component instances. The returned map is the controllers own
+// storage, so it must not be modified, and should not be used from systems running in parallel.
 func (c *Controller) GetEntity(entity int) map[reflect.Type]Component {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
 	for i := range c.entities {
 		if i == entity {
 			return c.entities[entity]
@@ -146,30 +220,35 @@ func (c *Controller) GetEntity(entity int) map[reflect.Type]Component {
 	return nil
 }
 
-// GetEntities returns a map of all entities and their component instances
+// GetEntities returns a map of all entities and their component instances. The returned map is the controllers own
+// storage, so it must not be modified, and should not be used from systems running in parallel.
 func (c *Controller) GetEntities() map[int]map[reflect.Type]Component {
 	return c.entities
 }
 
-// GetEntitiesWithComponent returns a list of all entities with a given component attached
-// TODO: Allow for passing a list of components
+// GetEntitiesWithComponent returns a list of all entities with a given component attached. To find entities based on
+// several components at once, use Query.
 func (c *Controller) GetEntitiesWithComponent(componentType reflect.Type) []int {
-	entitiesWithComponent := make([]int, 0)
-	for entity := range c.entities {
-		if c.HasComponent(entity, componentType) {
-			entitiesWithComponent = append(entitiesWithComponent, entity)
-		}
-	}
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	entitiesWithComponent := make([]int, len(c.components[componentType]))
+	copy(entitiesWithComponent, c.components[componentType])
 
 	return entitiesWithComponent
 }
 
-// UpdateComponent updates a component on an entity with a new version of the same component
+// UpdateComponent updates a component on an entity with a new version of the same component. OnUpdate observers are
+// notified of the change. If the entity does not have the component yet, it is added, and OnAdd observers are notified
+// instead.
 func (c *Controller) UpdateComponent(entity int, componentType reflect.Type, newComponent Component) int {
-	// First, remove the component in question (Don't actually update things, but rather remove and replace)
-	c.RemoveComponent(entity, componentType)
+	// If the new component is of a different type than the one being updated, the old one needs to be removed, rather
+	// than replaced
+	if reflect.TypeOf(newComponent) != componentType {
+		c.RemoveComponent(entity, componentType)
+	}
 
-	// Next, replace the removed component with the updated one
+	// Replace the existing component with the updated one
 	c.AddComponent(entity, newComponent)
 
 	return entity
@@ -177,8 +256,24 @@ func (c *Controller) UpdateComponent(entity int, componentType reflect.Type, new
 
 // RemoveComponent will delete a component instance from an entity, based on component type. It will also remove the
 // association between the component and the entity, and remove the component from the processor completely if no
-// other entities are using it.
+// other entities are using it. Any OnRemove observers are notified, if the entity had the component.
 func (c *Controller) RemoveComponent(entity int, componentType reflect.Type) int {
+	c.mutex.Lock()
+	removed, ok := c.entities[entity][componentType]
+	c.removeComponentLocked(entity, componentType)
+	c.updateViews(entity)
+	c.mutex.Unlock()
+
+	if ok {
+		c.notifyRemove(entity, componentType, removed)
+	}
+
+	return entity
+}
+
+// removeComponentLocked removes the association between an entity and a component type. The controllers lock must be
+// held, and views are not updated.
+func (c *Controller) removeComponentLocked(entity int, componentType reflect.Type) {
 	// Find the index of the entity to operate on in the components slice
 	index := -1
 	for i, v := range c.components[componentType] {
@@ -198,60 +293,92 @@ func (c *Controller) RemoveComponent(entity int, componentType reflect.Type) int
 
 	// Now, remove the component instance from the entity
 	delete(c.entities[entity], componentType)
-
-	return entity
 }
 
 // AddSystem registers a system to the controller. A priority can be provided, and systems will be processed in
-// numeric order, low to high. If multiple systems are registered as the same priority, they will be randomly run within
-// that priority group.
+// numeric order, low to high. If multiple systems are registered as the same priority, they will be run in the order
+// they were registered within that priority group. Only one system of each type can be added this way; the system is
+// named after its type (for example, "*game.MovementSystem"). Use AddNamedSystem to register several systems of the
+// same type, or to declare dependencies between systems.
 func (c *Controller) AddSystem(system System, priority int) {
-	systemType := reflect.TypeOf(system)
-
-	if _, ok := c.systems[systemType]; !ok {
-		// A system of this type has not been added yet, so add it to the systems list
-		c.systems[systemType] = system
-
-		// Now, append the system to a special list that will be used for sorting by priority
-		if !IntInSlice(priority, c.priorityKeys) {
-			c.priorityKeys = append(c.priorityKeys, priority)
-		}
-		c.sortedSystems[priority] = append(c.sortedSystems[priority], system)
-		sort.Ints(c.priorityKeys)
-	} else {
-		fmt.Printf("A system of type %v was already added to the controller %v!", systemType, c)
+	if err := c.AddNamedSystem(systemName(system), system, priority); err != nil {
+		fmt.Printf("Could not add a system of type %v to the controller: %v\n", reflect.TypeOf(system), err)
 	}
 }
 
-// Process kicks off system processing for all systems attached to the controller. Systems will be processed in the
-// order they are found, or if they have a priority, in priority order. If there is a mix of systems with priority and
-// without, systems with priority will be processed first (in order).
+// Process kicks off system processing for all systems attached to the controller. Systems are processed in priority
+// order, and within a priority group, in an order that satisfies their dependencies, falling back to the order they
+// were registered in. The order is the same every time Process is called. Systems that have been disabled, either
+// directly or through one of their groups, are skipped. If more than one worker has been configured with SetWorkers,
+// systems that do not conflict with each other may be processed concurrently.
+// Before any systems are run, events queued on the controllers EventBus are dispatched. Any structural changes recorded
+// on the controllers CommandBuffer are applied after each priority group, or once all systems have run, depending on
+// the controllers FlushMode. Once everything is done, a TickEvent is published on the EventBus.
 func (c *Controller) Process(excludedSystems []reflect.Type) {
+	c.events.Dispatch()
+
 	for _, key := range c.priorityKeys {
-		for _, system := range c.sortedSystems[key] {
-			systemType := reflect.TypeOf(system)
+		active := []*systemEntry{}
+		for _, entry := range c.sortedSystems[key] {
+			systemType := reflect.TypeOf(entry.system)
+
+			// Check if the current system type was marked as excluded on this call, or has been disabled. If it was, do
+			// not process it.
+			if !TypeInSlice(systemType, excludedSystems) && c.isEnabled(entry) {
+				active = append(active, entry)
+			}
+		}
 
-			// Check if the current system type was marked as excluded on this call. If it was, not process it.
-			if !TypeInSlice(systemType, excludedSystems) {
-				system.Process()
+		// Split the systems up into stages that can run concurrently. With a single worker, there is no point, so each
+		// system gets a stage of its own.
+		stages := [][]*systemEntry{}
+		if c.workers > 1 {
+			stages = buildStages(active)
+		} else {
+			for _, entry := range active {
+				stages = append(stages, []*systemEntry{entry})
 			}
 		}
+
+		for _, stage := range stages {
+			c.processStage(stage)
+		}
+
+		if c.flushMode == FlushAfterPriorityGroup {
+			c.FlushCommands()
+		}
 	}
+
+	c.FlushCommands()
+
+	c.tick++
+	Publish(c.events, TickEvent{Tick: c.tick})
 }
 
 // HasSystem checks the controller to see if it has a given system associated with it
 func (c *Controller) HasSystem(systemType reflect.Type) bool {
-	if _, ok := c.systems[systemType]; ok {
-		return true
-	}
-
-	return false
+	return c.findSystem(systemType) != nil
 }
 
-// ProcessSystem allows for on demand processing of individual systems, rather than processing all at once via Process
+// ProcessSystem allows for on demand processing of individual systems, rather than processing all at once via Process.
+// If several systems of the given type are registered, the first one in processing order is run. The system is run
+// even if it is disabled. Any commands recorded by the system are applied once it has finished.
 func (c *Controller) ProcessSystem(systemType reflect.Type) {
-	if c.HasSystem(systemType) {
-		system := c.systems[systemType]
-		system.Process()
+	if entry := c.findSystem(systemType); entry != nil {
+		c.processStage([]*systemEntry{entry})
+		c.FlushCommands()
+	}
+}
+
+// findSystem returns the first registered system of the given type, in processing order, or nil if there is none
+func (c *Controller) findSystem(systemType reflect.Type) *systemEntry {
+	for _, key := range c.priorityKeys {
+		for _, entry := range c.sortedSystems[key] {
+			if reflect.TypeOf(entry.system) == systemType {
+				return entry
+			}
+		}
 	}
+
+	return nil
 }
@@ -1,9 +1,12 @@
 package ecs
 
 import (
+	"bytes"
 	"github.com/stretchr/testify/assert"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 type PositionComponent struct {
@@ -98,6 +101,41 @@ func TestDeleteEntity(t *testing.T) {
 	assert.False(t, controller.HasComponent(entity, reflect.TypeOf(PositionComponent{})), "entity has component")
 }
 
+func TestEntityIDRecycling(t *testing.T) {
+	controller := NewController()
+
+	entity := controller.CreateEntity([]Component{PositionComponent{}})
+	entity2 := controller.CreateEntity([]Component{})
+	handle := controller.GetHandle(entity)
+
+	assert.True(t, controller.IsAlive(handle))
+
+	controller.DeleteEntity(entity)
+	assert.False(t, controller.IsAlive(handle), "handle to deleted entity should be stale")
+
+	// Deleting an entity twice should not free its ID twice
+	controller.DeleteEntity(entity)
+	assert.Equal(t, 1, len(controller.deadEntities))
+
+	// The freed ID should be handed out again, rather than a brand new one
+	entity3 := controller.CreateEntity([]Component{})
+	assert.Equal(t, entity, entity3)
+	assert.Equal(t, 2, controller.nextEntityID)
+	assert.False(t, controller.HasComponent(entity3, PositionComponent{}.TypeOf()), "recycled entity kept old components")
+
+	// The old handle should still be stale, even though the ID is in use again
+	assert.False(t, controller.IsAlive(handle))
+	assert.True(t, controller.IsAlive(controller.GetHandle(entity3)))
+	assert.Equal(t, handle.Generation+1, controller.GetHandle(entity3).Generation)
+
+	// Once all freed IDs have been used up, new IDs are handed out
+	entity4 := controller.CreateEntity([]Component{})
+	assert.NotEqual(t, entity2, entity4)
+	assert.Equal(t, 2, entity4)
+
+	assert.False(t, controller.IsAlive(EntityHandle{ID: 1000}))
+}
+
 func TestComponentMapping(t *testing.T) {
 	controller := NewController()
 
@@ -137,6 +175,170 @@ func TestGetEntitiesWithComponent(t *testing.T) {
 
 }
 
+func TestQuery(t *testing.T) {
+	controller := NewController()
+
+	entity := controller.CreateEntity([]Component{PositionComponent{}, AppearanceComponent{}})
+	entity2 := controller.CreateEntity([]Component{AppearanceComponent{}})
+	entity3 := controller.CreateEntity([]Component{PositionComponent{}, AppearanceComponent{}, RandomComponent{}})
+	entity4 := controller.CreateEntity([]Component{})
+
+	// With filters require all listed components
+	query := NewQuery().With(PositionComponent{}.TypeOf(), AppearanceComponent{}.TypeOf())
+	assert.Equal(t, []int{entity, entity3}, controller.Query(query))
+
+	// Without filters exclude any entity with the listed components
+	query = query.Without(RandomComponent{}.TypeOf())
+	assert.Equal(t, []int{entity}, controller.Query(query))
+
+	// Any filters require at least one of the listed components
+	query = NewQuery().Any(PositionComponent{}.TypeOf(), RandomComponent{}.TypeOf())
+	assert.Equal(t, []int{entity, entity3}, controller.Query(query))
+
+	// An empty query matches everything
+	assert.Equal(t, []int{entity, entity2, entity3, entity4}, controller.Query(NewQuery()))
+
+	// Filters can be provided in any order, and will share the same cached view
+	controller.Query(NewQuery().With(AppearanceComponent{}.TypeOf(), PositionComponent{}.TypeOf()))
+	controller.Query(NewQuery().With(PositionComponent{}.TypeOf(), AppearanceComponent{}.TypeOf()))
+	assert.Equal(t, 4, len(controller.views))
+}
+
+func TestQueryViewsStayInSync(t *testing.T) {
+	controller := NewController()
+
+	query := NewQuery().With(PositionComponent{}.TypeOf()).Without(RandomComponent{}.TypeOf())
+
+	entity := controller.CreateEntity([]Component{PositionComponent{}})
+	assert.Equal(t, []int{entity}, controller.Query(query))
+
+	// Adding a component after the view is built should be reflected in the results
+	entity2 := controller.CreateEntity([]Component{})
+	controller.AddComponent(entity2, PositionComponent{})
+	assert.Equal(t, []int{entity, entity2}, controller.Query(query))
+
+	// Adding an excluded component removes the entity from the view
+	controller.AddComponent(entity, RandomComponent{})
+	assert.Equal(t, []int{entity2}, controller.Query(query))
+
+	// Removing it again puts the entity back
+	controller.RemoveComponent(entity, RandomComponent{}.TypeOf())
+	assert.Equal(t, []int{entity, entity2}, controller.Query(query))
+
+	// Updating a component should not affect membership
+	controller.UpdateComponent(entity2, PositionComponent{}.TypeOf(), PositionComponent{X: 1, Y: 1})
+	assert.Equal(t, []int{entity, entity2}, controller.Query(query))
+
+	// Deleted entities are dropped from every view, including views that only exclude components
+	emptyQuery := NewQuery().Without(RandomComponent{}.TypeOf())
+	assert.Equal(t, []int{entity, entity2}, controller.Query(emptyQuery))
+
+	controller.DeleteEntity(entity)
+	assert.Equal(t, []int{entity2}, controller.Query(query))
+	assert.Equal(t, []int{entity2}, controller.Query(emptyQuery))
+
+	// Modifying the returned slice should not affect the cached view
+	results := controller.Query(query)
+	results[0] = 1000
+	assert.Equal(t, []int{entity2}, controller.Query(query))
+}
+
+func TestAddComponentTwice(t *testing.T) {
+	controller := NewController()
+
+	entity := controller.CreateEntity([]Component{PositionComponent{}})
+	controller.AddComponent(entity, PositionComponent{X: 5})
+
+	assert.Equal(t, []int{entity}, controller.GetEntitiesWithComponent(PositionComponent{}.TypeOf()))
+	assert.Equal(t, 5, controller.GetComponent(entity, PositionComponent{}.TypeOf()).(PositionComponent).X)
+
+	controller.RemoveComponent(entity, PositionComponent{}.TypeOf())
+	assert.Empty(t, controller.GetEntitiesWithComponent(PositionComponent{}.TypeOf()))
+}
+
+// HealthComponent is a component without a TypeOf method
+type HealthComponent struct {
+	HP int
+}
+
+func TestComponentsWithoutTypeOf(t *testing.T) {
+	controller := NewController()
+	controller.MapComponentClass("health", HealthComponent{})
+
+	entity := controller.CreateEntity([]Component{HealthComponent{HP: 10}, PositionComponent{}})
+	assert.Equal(t, reflect.TypeOf(HealthComponent{}), ComponentType[HealthComponent]())
+
+	health, ok := Get[HealthComponent](controller, entity)
+	assert.True(t, ok)
+	assert.Equal(t, 10, health.HP)
+
+	Add(controller, entity, HealthComponent{HP: 5})
+	assert.Equal(t, HealthComponent{HP: 5}, controller.GetComponent(entity, ComponentType[HealthComponent]()))
+	assert.Equal(t, []int{entity}, controller.Query(NewQuery().With(ComponentType[HealthComponent](), PositionComponent{}.TypeOf())))
+
+	visited := 0
+	Each2(controller, func(entity int, health HealthComponent, position PositionComponent) {
+		visited++
+	})
+	assert.Equal(t, 1, visited)
+
+	Remove[HealthComponent](controller, entity)
+	assert.False(t, Has[HealthComponent](controller, entity))
+}
+
+func TestGenericComponentAccess(t *testing.T) {
+	controller := NewController()
+
+	entity := controller.CreateEntity([]Component{})
+	assert.Equal(t, PositionComponent{}.TypeOf(), ComponentType[PositionComponent]())
+
+	Add(controller, entity, PositionComponent{X: 3, Y: 4})
+	assert.True(t, Has[PositionComponent](controller, entity))
+	assert.False(t, Has[AppearanceComponent](controller, entity))
+
+	position, ok := Get[PositionComponent](controller, entity)
+	assert.True(t, ok)
+	assert.Equal(t, PositionComponent{X: 3, Y: 4}, position)
+
+	// Components added through the generic helpers are visible to the reflection based API, and vice versa
+	assert.True(t, controller.HasComponent(entity, PositionComponent{}.TypeOf()))
+	controller.AddComponent(entity, AppearanceComponent{Appearance: "@"})
+	appearance, ok := Get[AppearanceComponent](controller, entity)
+	assert.True(t, ok)
+	assert.Equal(t, "@", appearance.Appearance)
+
+	Remove[PositionComponent](controller, entity)
+	assert.False(t, Has[PositionComponent](controller, entity))
+
+	position, ok = Get[PositionComponent](controller, entity)
+	assert.False(t, ok)
+	assert.Equal(t, PositionComponent{}, position)
+}
+
+func TestEach2(t *testing.T) {
+	controller := NewController()
+
+	entity := controller.CreateEntity([]Component{PositionComponent{X: 1}, AppearanceComponent{Appearance: "a"}})
+	controller.CreateEntity([]Component{AppearanceComponent{Appearance: "b"}})
+	entity3 := controller.CreateEntity([]Component{PositionComponent{X: 3}, AppearanceComponent{Appearance: "c"}})
+
+	visited := []int{}
+	Each2(controller, func(e int, position PositionComponent, appearance AppearanceComponent) {
+		visited = append(visited, e)
+
+		// Removing a component from a later entity during iteration should cause it to be skipped
+		Remove[AppearanceComponent](controller, entity3)
+	})
+
+	assert.Equal(t, []int{entity}, visited)
+
+	count := 0
+	Each(controller, func(e int, appearance AppearanceComponent) {
+		count++
+	})
+	assert.Equal(t, 2, count)
+}
+
 func TestUpdateComponent(t *testing.T) {
 	controller := NewController()
 
@@ -195,6 +397,66 @@ func TestRemoveComponent(t *testing.T) {
 	assert.True(t, controller.HasComponent(entity, PositionComponent{}.TypeOf()))
 }
 
+func TestComponentObservers(t *testing.T) {
+	controller := NewController()
+
+	added := []Component{}
+	removed := []Component{}
+	updated := [][]Component{}
+	deleted := []int{}
+
+	controller.OnAdd(PositionComponent{}.TypeOf(), func(entity int, component Component) {
+		// Observers run after the change, so the component should already be attached
+		assert.True(t, controller.HasComponent(entity, PositionComponent{}.TypeOf()))
+		added = append(added, component)
+	})
+	controller.OnRemove(PositionComponent{}.TypeOf(), func(entity int, component Component) {
+		assert.False(t, controller.HasComponent(entity, PositionComponent{}.TypeOf()))
+		removed = append(removed, component)
+	})
+	controller.OnUpdate(PositionComponent{}.TypeOf(), func(entity int, previous, current Component) {
+		updated = append(updated, []Component{previous, current})
+	})
+	controller.OnEntityDeleted(func(entity int) {
+		deleted = append(deleted, entity)
+	})
+
+	// Creating an entity with a component fires OnAdd
+	entity := controller.CreateEntity([]Component{PositionComponent{X: 1}, AppearanceComponent{}})
+	assert.Equal(t, []Component{PositionComponent{X: 1}}, added)
+
+	// Updating a component fires OnUpdate with both versions, but not OnAdd or OnRemove
+	controller.UpdateComponent(entity, PositionComponent{}.TypeOf(), PositionComponent{X: 2})
+	assert.Equal(t, [][]Component{{PositionComponent{X: 1}, PositionComponent{X: 2}}}, updated)
+	assert.Equal(t, 1, len(added))
+	assert.Equal(t, 0, len(removed))
+
+	// Adding a component the entity already has counts as an update
+	controller.AddComponent(entity, PositionComponent{X: 3})
+	assert.Equal(t, 2, len(updated))
+	assert.Equal(t, 1, len(added))
+
+	// Updating a component the entity does not have counts as an add
+	entity2 := controller.CreateEntity([]Component{})
+	controller.UpdateComponent(entity2, PositionComponent{}.TypeOf(), PositionComponent{X: 5})
+	assert.Equal(t, 2, len(added))
+	assert.Equal(t, 2, len(updated))
+
+	// Removing a component fires OnRemove, but removing one that isn't present does not
+	controller.RemoveComponent(entity2, PositionComponent{}.TypeOf())
+	controller.RemoveComponent(entity2, PositionComponent{}.TypeOf())
+	assert.Equal(t, []Component{PositionComponent{X: 5}}, removed)
+
+	// Observers for other component types are not called
+	controller.RemoveComponent(entity, AppearanceComponent{}.TypeOf())
+	assert.Equal(t, 1, len(removed))
+
+	// Deleting an entity fires OnRemove for each component, followed by OnEntityDeleted
+	controller.DeleteEntity(entity)
+	assert.Equal(t, []Component{PositionComponent{X: 5}, PositionComponent{X: 3}}, removed)
+	assert.Equal(t, []int{entity}, deleted)
+}
+
 // System tests
 
 type TestSystem struct {
@@ -309,3 +571,910 @@ func TestProcessSingleSystem(t *testing.T) {
 	controller.ProcessSystem(reflect.TypeOf(&OneMoreSystem{}))
 	assert.False(t, system3.SystemRun)
 }
+
+type RecordingSystem struct {
+	name string
+	log  *[]string
+}
+
+func (rs *RecordingSystem) Process() {
+	*rs.log = append(*rs.log, rs.name)
+}
+
+func TestNamedSystemOrdering(t *testing.T) {
+	controller := NewController()
+	log := []string{}
+
+	newSystem := func(name string) *RecordingSystem {
+		return &RecordingSystem{name: name, log: &log}
+	}
+
+	// Several systems of the same type can be registered under different names
+	assert.Nil(t, controller.AddNamedSystem("render", newSystem("render"), 2))
+	assert.Nil(t, controller.AddNamedSystem("movement", newSystem("movement"), 1, Before("collision")))
+	assert.Nil(t, controller.AddNamedSystem("ai", newSystem("ai"), 1, Before("movement")))
+	assert.Nil(t, controller.AddNamedSystem("collision", newSystem("collision"), 1))
+	assert.Nil(t, controller.AddNamedSystem("damage", newSystem("damage"), 1, After("collision")))
+	assert.Nil(t, controller.AddNamedSystem("input", newSystem("input"), 1))
+
+	// Dependencies on systems that don't exist are ignored
+	assert.Nil(t, controller.AddNamedSystem("sound", newSystem("sound"), 2, After("music")))
+
+	assert.True(t, controller.HasNamedSystem("ai"))
+	assert.True(t, controller.HasSystem(reflect.TypeOf(&RecordingSystem{})))
+
+	controller.Process([]reflect.Type{})
+
+	// Dependencies are satisfied, and otherwise registration order is used
+	expected := []string{"ai", "movement", "collision", "damage", "input", "render", "sound"}
+	assert.Equal(t, expected, log)
+
+	// Processing order is the same every time
+	for i := 0; i < 10; i++ {
+		log = []string{}
+		controller.Process([]reflect.Type{})
+		assert.Equal(t, expected, log)
+	}
+
+	log = []string{}
+	controller.ProcessNamedSystem("damage")
+	assert.Equal(t, []string{"damage"}, log)
+}
+
+func TestNamedSystemErrors(t *testing.T) {
+	controller := NewController()
+	log := []string{}
+
+	assert.Nil(t, controller.AddNamedSystem("a", &RecordingSystem{name: "a", log: &log}, 1, Before("b")))
+	assert.Nil(t, controller.AddNamedSystem("b", &RecordingSystem{name: "b", log: &log}, 1, Before("c")))
+
+	// Names must be unique
+	assert.NotNil(t, controller.AddNamedSystem("a", &RecordingSystem{name: "a", log: &log}, 1))
+
+	// A system that closes a cycle is rejected, and not registered
+	err := controller.AddNamedSystem("c", &RecordingSystem{name: "c", log: &log}, 1, Before("a"))
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "cycle")
+	assert.False(t, controller.HasNamedSystem("c"))
+
+	// Dependencies that contradict priorities are rejected
+	err = controller.AddNamedSystem("d", &RecordingSystem{name: "d", log: &log}, 0, After("a"))
+	assert.NotNil(t, err)
+	assert.False(t, controller.HasNamedSystem("d"))
+
+	// Dependencies that agree with priorities are fine
+	assert.Nil(t, controller.AddNamedSystem("e", &RecordingSystem{name: "e", log: &log}, 2, After("a")))
+
+	controller.Process([]reflect.Type{})
+	assert.Equal(t, []string{"a", "b", "e"}, log)
+}
+
+func TestEnableDisableAndRemoveSystems(t *testing.T) {
+	controller := NewController()
+	log := []string{}
+
+	newSystem := func(name string) *RecordingSystem {
+		return &RecordingSystem{name: name, log: &log}
+	}
+
+	assert.Nil(t, controller.AddNamedSystem("input", newSystem("input"), 0))
+	assert.Nil(t, controller.AddNamedSystem("ai", newSystem("ai"), 1, InGroup("world")))
+	assert.Nil(t, controller.AddNamedSystem("movement", newSystem("movement"), 1, InGroup("world"), After("ai")))
+	assert.Nil(t, controller.AddNamedSystem("menu", newSystem("menu"), 2, InGroup("ui")))
+	controller.AddSystem(&TestSystem{}, 3)
+
+	// Pausing the world group skips every system in it
+	controller.SetGroupEnabled("world", false)
+	assert.False(t, controller.IsGroupEnabled("world"))
+	assert.False(t, controller.IsSystemEnabled("ai"))
+	controller.Process([]reflect.Type{})
+	assert.Equal(t, []string{"input", "menu"}, log)
+
+	// Unpausing restores them, in their original order
+	log = []string{}
+	controller.SetGroupEnabled("world", true)
+	controller.SetGroupEnabled("ui", false)
+	controller.Process([]reflect.Type{})
+	assert.Equal(t, []string{"input", "ai", "movement"}, log)
+
+	// Individual systems can be disabled, regardless of their groups
+	log = []string{}
+	controller.SetGroupEnabled("ui", true)
+	assert.Nil(t, controller.SetSystemEnabled("ai", false))
+	assert.NotNil(t, controller.SetSystemEnabled("does not exist", false))
+	controller.Process([]reflect.Type{})
+	assert.Equal(t, []string{"input", "movement", "menu"}, log)
+
+	// A system that is disabled can still be run on demand
+	log = []string{}
+	controller.ProcessNamedSystem("ai")
+	assert.Equal(t, []string{"ai"}, log)
+
+	// Removing a system unregisters it completely
+	assert.Nil(t, controller.SetSystemEnabled("ai", true))
+	assert.Nil(t, controller.RemoveSystem("input"))
+	assert.NotNil(t, controller.RemoveSystem("input"))
+	assert.False(t, controller.HasNamedSystem("input"))
+
+	log = []string{}
+	controller.Process([]reflect.Type{})
+	assert.Equal(t, []string{"ai", "movement", "menu"}, log)
+
+	// Systems added with AddSystem are named after their type
+	assert.True(t, controller.HasSystem(reflect.TypeOf(&TestSystem{})))
+	assert.Nil(t, controller.RemoveSystem("*ecs.TestSystem"))
+	assert.False(t, controller.HasSystem(reflect.TypeOf(&TestSystem{})))
+	assert.Equal(t, 0, len(controller.sortedSystems[3]))
+}
+
+// Serialization tests
+
+func newSerializationController() *Controller {
+	controller := NewController()
+	controller.MapComponentClass("position", PositionComponent{})
+	controller.MapComponentClass("appearance", AppearanceComponent{})
+
+	return controller
+}
+
+func TestSaveAndLoad(t *testing.T) {
+	controller := newSerializationController()
+
+	system1 := &TestSystem{}
+	system2 := &AnotherSystem{}
+	system3 := &OneMoreSystem{}
+	controller.AddSystem(system1, 1)
+	controller.AddSystem(system2, 1)
+	controller.AddSystem(system3, 2)
+
+	player := controller.CreateEntity([]Component{PositionComponent{X: 4, Y: 2}, AppearanceComponent{Appearance: "@"}})
+	deleted := controller.CreateEntity([]Component{PositionComponent{X: 1, Y: 1}})
+	monster := controller.CreateEntity([]Component{AppearanceComponent{Appearance: "r"}})
+	controller.DeleteEntity(deleted)
+	deletedHandle := EntityHandle{ID: deleted, Generation: 0}
+
+	var buffer bytes.Buffer
+	err := controller.Save(&buffer)
+	assert.Nil(t, err)
+
+	// Register the same components and systems on a fresh controller, but register the systems in a different order
+	restored := newSerializationController()
+	restored.AddSystem(&OneMoreSystem{}, 2)
+	restored.AddSystem(&AnotherSystem{}, 1)
+	restored.AddSystem(&TestSystem{}, 1)
+
+	// Observers are only called once the whole world has been restored
+	added := 0
+	restored.OnAdd(AppearanceComponent{}.TypeOf(), func(entity int, component Component) {
+		added++
+		assert.Equal(t, 2, len(restored.entities))
+		assert.True(t, Has[PositionComponent](restored, player))
+	})
+
+	err = restored.Load(&buffer)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, added)
+
+	assert.Equal(t, controller.nextEntityID, restored.nextEntityID)
+	assert.Equal(t, len(controller.entities), len(restored.entities))
+
+	position, ok := Get[PositionComponent](restored, player)
+	assert.True(t, ok)
+	assert.Equal(t, PositionComponent{X: 4, Y: 2}, position)
+
+	appearance, ok := Get[AppearanceComponent](restored, monster)
+	assert.True(t, ok)
+	assert.Equal(t, "r", appearance.Appearance)
+	assert.False(t, Has[PositionComponent](restored, monster))
+
+	// Entity bookkeeping should survive the round trip, so stale handles stay stale and IDs are recycled the same way
+	assert.False(t, restored.IsAlive(deletedHandle))
+	assert.Equal(t, deleted, restored.CreateEntity([]Component{}))
+
+	// Queries on the restored controller should see the loaded entities
+	assert.Equal(t, []int{player}, restored.Query(NewQuery().With(PositionComponent{}.TypeOf())))
+
+	// Systems should be processed in the order they were saved in
+	assert.IsType(t, &TestSystem{}, restored.sortedSystems[1][0].system)
+	assert.IsType(t, &AnotherSystem{}, restored.sortedSystems[1][1].system)
+	assert.IsType(t, &OneMoreSystem{}, restored.sortedSystems[2][0].system)
+}
+
+func TestSaveUnregisteredComponent(t *testing.T) {
+	controller := newSerializationController()
+	controller.CreateEntity([]Component{PositionComponent{}, RandomComponent{}})
+
+	var buffer bytes.Buffer
+	err := controller.Save(&buffer)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "RandomComponent")
+	assert.Equal(t, 0, buffer.Len())
+}
+
+func TestLoadErrors(t *testing.T) {
+	controller := newSerializationController()
+	controller.CreateEntity([]Component{PositionComponent{X: 1}})
+	controller.AddSystem(&TestSystem{}, 1)
+
+	var buffer bytes.Buffer
+	assert.Nil(t, controller.Save(&buffer))
+	saved := buffer.String()
+
+	// A component that isn't registered on the target controller should produce an error
+	restored := NewController()
+	restored.AddSystem(&TestSystem{}, 1)
+	err := restored.Load(strings.NewReader(saved))
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "position")
+	assert.Equal(t, 0, len(restored.entities))
+
+	// As should a system that isn't registered
+	restored = newSerializationController()
+	err = restored.Load(strings.NewReader(saved))
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "TestSystem")
+
+	// Loading into a controller that is already in use is not allowed
+	err = controller.Load(strings.NewReader(saved))
+	assert.NotNil(t, err)
+
+	// Unknown versions are rejected
+	restored = newSerializationController()
+	restored.AddSystem(&TestSystem{}, 1)
+	err = restored.Load(strings.NewReader(strings.Replace(saved, `"version":1`, `"version":99`, 1)))
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "version")
+}
+
+// Command buffer tests
+
+type ReplacingSystem struct {
+	controller *Controller
+	seen       int
+}
+
+// Process replaces every entity with a position with a new entity with an appearance, via the command buffer
+func (rs *ReplacingSystem) Process() {
+	for entity := range rs.controller.GetEntities() {
+		if rs.controller.HasComponent(entity, PositionComponent{}.TypeOf()) {
+			rs.controller.Commands().DeleteEntity(entity)
+			rs.controller.Commands().CreateEntity([]Component{AppearanceComponent{}})
+		}
+	}
+
+	rs.seen = len(rs.controller.GetEntities())
+}
+
+type CountingSystem struct {
+	controller *Controller
+	seen       int
+}
+
+func (cs *CountingSystem) Process() {
+	cs.seen = len(cs.controller.GetEntitiesWithComponent(AppearanceComponent{}.TypeOf()))
+}
+
+func TestCommandBufferFlushAfterFrame(t *testing.T) {
+	controller := NewController()
+
+	for i := 0; i < 5; i++ {
+		controller.CreateEntity([]Component{PositionComponent{X: i}})
+	}
+
+	replacer := &ReplacingSystem{controller: controller}
+	counter := &CountingSystem{controller: controller}
+	controller.AddSystem(replacer, 1)
+	controller.AddSystem(counter, 2)
+
+	controller.Process([]reflect.Type{})
+
+	// Nothing should have changed while systems were running
+	assert.Equal(t, 5, replacer.seen)
+	assert.Equal(t, 0, counter.seen)
+
+	// But everything should be applied once the frame is complete
+	assert.Equal(t, 0, controller.commands.Len())
+	assert.Empty(t, controller.GetEntitiesWithComponent(PositionComponent{}.TypeOf()))
+	assert.Equal(t, 5, len(controller.GetEntitiesWithComponent(AppearanceComponent{}.TypeOf())))
+	assert.Equal(t, 5, len(controller.GetEntities()))
+}
+
+func TestCommandBufferFlushAfterPriorityGroup(t *testing.T) {
+	controller := NewController()
+	controller.SetFlushMode(FlushAfterPriorityGroup)
+
+	for i := 0; i < 5; i++ {
+		controller.CreateEntity([]Component{PositionComponent{X: i}})
+	}
+
+	replacer := &ReplacingSystem{controller: controller}
+	counter := &CountingSystem{controller: controller}
+	controller.AddSystem(replacer, 1)
+	controller.AddSystem(counter, 2)
+
+	controller.Process([]reflect.Type{})
+
+	// The second priority group should see the changes made by the first
+	assert.Equal(t, 5, replacer.seen)
+	assert.Equal(t, 5, counter.seen)
+}
+
+func TestCommandBufferStaleCommands(t *testing.T) {
+	controller := NewController()
+
+	entity := controller.CreateEntity([]Component{PositionComponent{}})
+
+	// Commands are applied in order, so adding a component to an entity deleted earlier in the same flush is a no-op,
+	// even though the entity ID has been re-used by the time the add is applied
+	controller.Commands().DeleteEntity(entity)
+	controller.Commands().CreateEntity([]Component{})
+	controller.Commands().AddComponent(entity, AppearanceComponent{})
+	controller.Commands().RemoveComponent(entity, PositionComponent{}.TypeOf())
+	assert.Equal(t, 4, controller.Commands().Len())
+
+	controller.FlushCommands()
+
+	assert.Equal(t, 1, len(controller.GetEntities()))
+	assert.False(t, controller.HasComponent(entity, AppearanceComponent{}.TypeOf()))
+
+	// Commands against live entities are applied
+	controller.Commands().AddComponent(entity, PositionComponent{X: 3})
+	controller.FlushCommands()
+	assert.True(t, controller.HasComponent(entity, PositionComponent{}.TypeOf()))
+
+	controller.Commands().RemoveComponent(entity, PositionComponent{}.TypeOf())
+	controller.FlushCommands()
+	assert.False(t, controller.HasComponent(entity, PositionComponent{}.TypeOf()))
+}
+
+// Parallel processing tests
+
+type RendezvousSystem struct {
+	arrived chan bool
+	other   chan bool
+	met     bool
+}
+
+// Process signals that this system is running, and waits for the other system to do the same. This can only succeed
+// if both systems are running at the same time.
+func (rs *RendezvousSystem) Process() {
+	close(rs.arrived)
+
+	select {
+	case <-rs.other:
+		rs.met = true
+	case <-time.After(time.Second):
+		rs.met = false
+	}
+}
+
+type MovingSystem struct {
+	name       string
+	controller *Controller
+}
+
+// Process moves every entity one step, and spawns a new entity through the systems own command buffer
+func (ms *MovingSystem) Process() {
+	Each(ms.controller, func(entity int, position PositionComponent) {
+		Add(ms.controller, entity, PositionComponent{X: position.X + 1, Y: position.Y})
+	})
+
+	ms.controller.CommandsFor(ms.name).CreateEntity([]Component{AppearanceComponent{Appearance: ms.name}})
+}
+
+type RenamingSystem struct {
+	name       string
+	controller *Controller
+}
+
+// Process updates the appearance of every entity, and spawns a new entity through the systems own command buffer
+func (rs *RenamingSystem) Process() {
+	Each(rs.controller, func(entity int, appearance AppearanceComponent) {
+		Add(rs.controller, entity, AppearanceComponent{Appearance: appearance.Appearance + "!"})
+	})
+
+	rs.controller.CommandsFor(rs.name).CreateEntity([]Component{AppearanceComponent{Appearance: rs.name}})
+}
+
+func TestBuildStages(t *testing.T) {
+	positionType := PositionComponent{}.TypeOf()
+	appearanceType := AppearanceComponent{}.TypeOf()
+
+	newEntry := func(name string, options ...SystemOption) *systemEntry {
+		entry := &systemEntry{name: name}
+		for _, option := range options {
+			option(entry)
+		}
+		return entry
+	}
+
+	movement := newEntry("movement", Reads(positionType), Writes(positionType))
+	render := newEntry("render", Reads(appearanceType))
+	animation := newEntry("animation", Writes(appearanceType))
+	camera := newEntry("camera", Reads(positionType))
+	log := newEntry("log", Reads(appearanceType), After("camera"))
+	legacy := newEntry("legacy")
+
+	stages := buildStages([]*systemEntry{movement, render, animation, camera, log, legacy})
+
+	// Render and movement don't overlap. Animation writes what render reads, so it starts a new stage. Camera reads
+	// what movement wrote, but movement is in an earlier stage, so camera can join animation. Log depends on camera,
+	// and legacy declares nothing, so both run on their own.
+	assert.Equal(t, [][]*systemEntry{{movement, render}, {animation, camera}, {log}, {legacy}}, stages)
+}
+
+func TestParallelProcessing(t *testing.T) {
+	controller := NewController()
+	controller.SetWorkers(2)
+
+	first := &RendezvousSystem{arrived: make(chan bool), other: make(chan bool)}
+	second := &RendezvousSystem{arrived: first.other, other: first.arrived}
+
+	assert.Nil(t, controller.AddNamedSystem("first", first, 1, Reads(PositionComponent{}.TypeOf())))
+	assert.Nil(t, controller.AddNamedSystem("second", second, 1, Reads(PositionComponent{}.TypeOf())))
+
+	controller.Process([]reflect.Type{})
+
+	assert.True(t, first.met, "systems were not processed concurrently")
+	assert.True(t, second.met, "systems were not processed concurrently")
+}
+
+func TestParallelProcessingIsDeterministic(t *testing.T) {
+	run := func(workers int) []string {
+		controller := NewController()
+		controller.MapComponentClass("position", PositionComponent{})
+		controller.MapComponentClass("appearance", AppearanceComponent{})
+		controller.SetWorkers(workers)
+
+		for i := 0; i < 100; i++ {
+			controller.CreateEntity([]Component{PositionComponent{X: i}, AppearanceComponent{Appearance: "e"}})
+		}
+
+		positionType := PositionComponent{}.TypeOf()
+		appearanceType := AppearanceComponent{}.TypeOf()
+
+		controller.AddNamedSystem("movement", &MovingSystem{name: "movement", controller: controller}, 1, Reads(positionType), Writes(positionType))
+		controller.AddNamedSystem("renaming", &RenamingSystem{name: "renaming", controller: controller}, 1, Reads(appearanceType), Writes(appearanceType))
+
+		for i := 0; i < 5; i++ {
+			controller.Process([]reflect.Type{})
+		}
+
+		var buffer bytes.Buffer
+		assert.Nil(t, controller.Save(&buffer))
+
+		// Return the saved entities, split on entity boundaries, so failures are easier to read
+		return strings.Split(buffer.String(), "},{")
+	}
+
+	serial := run(1)
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, serial, run(4))
+	}
+}
+
+// FuncSystem runs a function as its Process
+type FuncSystem struct {
+	process func()
+}
+
+func (fs *FuncSystem) Process() {
+	fs.process()
+}
+
+func TestDeleteEntityDuringParallelProcessing(t *testing.T) {
+	controller := NewController()
+	controller.SetWorkers(2)
+
+	positionType := PositionComponent{}.TypeOf()
+	appearanceType := AppearanceComponent{}.TypeOf()
+
+	removed := 0
+	controller.OnRemove(appearanceType, func(entity int, component Component) {
+		removed++
+	})
+
+	// Slow observers give the other system plenty of time to add components while entities are being deleted
+	controller.OnRemove(positionType, func(entity int, component Component) {
+		time.Sleep(10 * time.Microsecond)
+	})
+
+	// One system deletes entities while another, declared as not conflicting with it, adds components to them
+	entities := []int{}
+	controller.AddNamedSystem("deleting", &FuncSystem{process: func() {
+		for _, entity := range entities {
+			controller.DeleteEntity(entity)
+		}
+	}}, 1, Writes(positionType))
+	controller.AddNamedSystem("decorating", &FuncSystem{process: func() {
+		for _, entity := range entities {
+			controller.AddComponent(entity, AppearanceComponent{Appearance: "a"})
+		}
+	}}, 1, Writes(appearanceType))
+
+	for i := 0; i < 10; i++ {
+		entities = []int{}
+		for j := 0; j < 50; j++ {
+			entities = append(entities, controller.CreateEntity([]Component{PositionComponent{X: j}}))
+		}
+
+		controller.Process([]reflect.Type{})
+
+		// Every entity associated with a component type must still have that component. A component added while the
+		// entity was being deleted has either been removed with it, or has brought the entity back to life.
+		for componentType, associated := range controller.components {
+			for _, entity := range associated {
+				_, ok := controller.entities[entity][componentType]
+				assert.True(t, ok, "entity %v is associated with %v, but does not have it", entity, componentType)
+			}
+		}
+
+		for _, entity := range entities {
+			controller.DeleteEntity(entity)
+		}
+	}
+
+	// Every appearance that was added was removed again, and its observers told about it
+	assert.Empty(t, controller.components[appearanceType])
+	assert.Greater(t, removed, 0)
+}
+
+func TestSharedCommandsDuringParallelProcessing(t *testing.T) {
+	controller := NewController()
+	controller.SetWorkers(2)
+
+	positionType := PositionComponent{}.TypeOf()
+	appearanceType := AppearanceComponent{}.TypeOf()
+
+	// Two systems that run together, both recording on the shared buffer. The second always records first, but the
+	// commands are still applied in processing order.
+	secondRecorded := make(chan bool)
+	controller.AddNamedSystem("first", &FuncSystem{process: func() {
+		<-secondRecorded
+		controller.Commands().CreateEntity([]Component{AppearanceComponent{Appearance: "first"}})
+	}}, 1, Reads(positionType))
+	controller.AddNamedSystem("second", &FuncSystem{process: func() {
+		controller.Commands().CreateEntity([]Component{AppearanceComponent{Appearance: "second"}})
+		close(secondRecorded)
+	}}, 1, Reads(positionType))
+
+	controller.Process([]reflect.Type{})
+
+	entities := controller.GetEntitiesWithComponent(appearanceType)
+	assert.Len(t, entities, 2)
+	assert.Equal(t, "first", controller.GetComponent(entities[0], appearanceType).(AppearanceComponent).Appearance)
+	assert.Equal(t, "second", controller.GetComponent(entities[1], appearanceType).(AppearanceComponent).Appearance)
+
+	// Commands recorded outside of Process still go to the shared buffer
+	controller.Commands().CreateEntity([]Component{})
+	assert.Equal(t, 1, controller.Commands().Len())
+}
+
+// Event bus tests
+
+type DamageEvent struct {
+	Target int
+	Amount int
+}
+
+type HealEvent struct {
+	Target int
+	Amount int
+}
+
+func TestEventBusPublish(t *testing.T) {
+	bus := NewEventBus()
+
+	received := []DamageEvent{}
+	healed := 0
+
+	subscription := Subscribe(bus, func(event DamageEvent) {
+		received = append(received, event)
+	})
+	Subscribe(bus, func(event HealEvent) {
+		healed += event.Amount
+	})
+
+	// Published events are delivered immediately, only to handlers of the matching type
+	Publish(bus, DamageEvent{Target: 1, Amount: 5})
+	assert.Equal(t, []DamageEvent{{Target: 1, Amount: 5}}, received)
+	assert.Equal(t, 0, healed)
+
+	Publish(bus, HealEvent{Target: 1, Amount: 3})
+	assert.Equal(t, 3, healed)
+
+	// Unsubscribed handlers no longer receive events
+	bus.Unsubscribe(subscription)
+	Publish(bus, DamageEvent{Target: 2, Amount: 1})
+	assert.Equal(t, 1, len(received))
+}
+
+func TestEventBusQueue(t *testing.T) {
+	bus := NewEventBus()
+
+	order := []string{}
+	Subscribe(bus, func(event DamageEvent) {
+		order = append(order, "damage")
+
+		// Events queued while dispatching wait until the next dispatch
+		Enqueue(bus, HealEvent{})
+	})
+	Subscribe(bus, func(event HealEvent) {
+		order = append(order, "heal")
+	})
+
+	Enqueue(bus, HealEvent{})
+	Enqueue(bus, DamageEvent{})
+	assert.Equal(t, 2, bus.Pending())
+	assert.Empty(t, order)
+
+	bus.Dispatch()
+	assert.Equal(t, []string{"heal", "damage"}, order)
+	assert.Equal(t, 1, bus.Pending())
+
+	bus.Dispatch()
+	assert.Equal(t, []string{"heal", "damage", "heal"}, order)
+	assert.Equal(t, 0, bus.Pending())
+}
+
+func TestControllerDispatchesEventsOnProcess(t *testing.T) {
+	controller := NewController()
+	system := &TestSystem{}
+	controller.AddSystem(system, 1)
+
+	var systemRunWhenDelivered bool
+	Subscribe(controller.Events(), func(event DamageEvent) {
+		systemRunWhenDelivered = system.SystemRun
+	})
+
+	Enqueue(controller.Events(), DamageEvent{Target: 1, Amount: 1})
+	controller.Process([]reflect.Type{})
+
+	// Queued events are delivered at the start of the tick, before any systems run
+	assert.Equal(t, 0, controller.Events().Pending())
+	assert.False(t, systemRunWhenDelivered)
+}
+
+func TestSystemMessageQueue(t *testing.T) {
+	bus := NewEventBus()
+	smq := NewSystemMessageQueueOnBus(bus)
+
+	subscriber := &TestSystem{}
+	other := &AnotherSystem{}
+	attack := SystemMessageType{Name: "attack"}
+	move := SystemMessageType{Name: "move"}
+
+	smq.Subscriptions[subscriber] = []SystemMessageType{attack}
+	smq.Subscriptions[other] = []SystemMessageType{move}
+
+	// Messages broadcast on the queue are also visible as typed events on the bus
+	busMessages := 0
+	Subscribe(bus, func(message SystemMessage) {
+		busMessages++
+	})
+
+	smq.BroadcastMessage(attack, map[string]string{"target": "1"}, other)
+	smq.BroadcastMessage(attack, map[string]string{"target": "2"}, other)
+
+	messages := smq.GetSubscribedMessages(subscriber)
+	assert.Equal(t, 2, len(messages))
+	assert.Equal(t, "1", messages[0].MessageContent["target"])
+	assert.Empty(t, smq.GetSubscribedMessages(other))
+	assert.Equal(t, 2, busMessages)
+
+	// Queues created without a constructor still deliver messages
+	bare := SystemMessageQueue{Messages: make(map[System][]SystemMessage), Subscriptions: map[System][]SystemMessageType{subscriber: {attack}}}
+	bare.BroadcastMessage(attack, map[string]string{}, other)
+	assert.Equal(t, 1, len(bare.GetSubscribedMessages(subscriber)))
+}
+
+func TestSystemMessageQueueDeleteMessages(t *testing.T) {
+	smq := NewSystemMessageQueue()
+
+	subscriber := &TestSystem{}
+	attack := SystemMessageType{Name: "attack"}
+	move := SystemMessageType{Name: "move"}
+	smq.Subscribe(subscriber, attack, move)
+
+	smq.BroadcastMessage(attack, map[string]string{"n": "1"}, nil)
+	smq.BroadcastMessage(move, map[string]string{"n": "2"}, nil)
+	smq.BroadcastMessage(attack, map[string]string{"n": "3"}, nil)
+	smq.BroadcastMessage(move, map[string]string{"n": "4"}, nil)
+	smq.BroadcastMessage(attack, map[string]string{"n": "5"}, nil)
+
+	// Every matching message is removed, and the remaining ones keep their order
+	smq.DeleteMessages("attack", subscriber)
+	messages := smq.GetSubscribedMessages(subscriber)
+	assert.Equal(t, 2, len(messages))
+	assert.Equal(t, "2", messages[0].MessageContent["n"])
+	assert.Equal(t, "4", messages[1].MessageContent["n"])
+}
+
+func TestSystemMessageQueuePriority(t *testing.T) {
+	smq := NewSystemMessageQueue()
+
+	subscriber := &TestSystem{}
+	alert := SystemMessageType{Name: "alert"}
+	smq.Subscribe(subscriber, alert)
+
+	smq.SendMessage(SystemMessage{MessageType: alert, MessageContent: map[string]string{"n": "low"}})
+	smq.SendMessage(SystemMessage{MessageType: alert, MessageContent: map[string]string{"n": "high"}, Priority: 10})
+	smq.SendMessage(SystemMessage{MessageType: alert, MessageContent: map[string]string{"n": "low2"}})
+	smq.SendMessage(SystemMessage{MessageType: alert, MessageContent: map[string]string{"n": "high2"}, Priority: 10})
+
+	order := []string{}
+	for _, message := range smq.GetSubscribedMessages(subscriber) {
+		order = append(order, message.MessageContent["n"])
+	}
+	assert.Equal(t, []string{"high", "high2", "low", "low2"}, order)
+}
+
+func TestSystemMessageQueueLifecycle(t *testing.T) {
+	smq := NewSystemMessageQueue()
+
+	reader := &TestSystem{}
+	idle := &AnotherSystem{}
+	alert := SystemMessageType{Name: "alert"}
+	smq.Subscribe(reader, alert)
+	smq.Subscribe(idle, alert)
+
+	smq.SendMessage(SystemMessage{MessageType: alert, TTL: 2})
+	smq.SendMessage(SystemMessage{MessageType: alert})
+
+	// Read messages are cleared at the end of the tick, unread ones stay until they expire
+	assert.Equal(t, 2, len(smq.GetSubscribedMessages(reader)))
+	smq.Tick()
+	assert.Empty(t, smq.GetSubscribedMessages(reader))
+	assert.Equal(t, 2, len(smq.Messages[idle]))
+
+	smq.Tick()
+	assert.Equal(t, 1, len(smq.Messages[idle]))
+	assert.Equal(t, 0, smq.Messages[idle][0].TTL)
+
+	// Messages sent after a tick are not marked as read by reads from the previous tick
+	smq.SendMessage(SystemMessage{MessageType: alert})
+	smq.Tick()
+	assert.Equal(t, 1, len(smq.GetSubscribedMessages(reader)))
+}
+
+func TestSystemMessageQueueUnsubscribe(t *testing.T) {
+	smq := NewSystemMessageQueue()
+
+	subscriber := &TestSystem{}
+	attack := SystemMessageType{Name: "attack"}
+	move := SystemMessageType{Name: "move"}
+	smq.Subscribe(subscriber, attack, move)
+
+	smq.BroadcastMessage(attack, map[string]string{}, nil)
+	smq.BroadcastMessage(move, map[string]string{}, nil)
+
+	smq.Unsubscribe(subscriber, attack)
+	assert.Equal(t, []SystemMessageType{move}, smq.Subscriptions[subscriber])
+	assert.Equal(t, 1, len(smq.Messages[subscriber]))
+
+	smq.BroadcastMessage(attack, map[string]string{}, nil)
+	assert.Equal(t, 1, len(smq.Messages[subscriber]))
+
+	smq.Unsubscribe(subscriber)
+	assert.Empty(t, smq.Subscriptions)
+	assert.Empty(t, smq.GetSubscribedMessages(subscriber))
+}
+
+func TestControllerTicksMessageQueue(t *testing.T) {
+	controller := NewController()
+	smq := NewSystemMessageQueueOnBus(controller.Events())
+
+	subscriber := &TestSystem{}
+	alert := SystemMessageType{Name: "alert"}
+	smq.Subscribe(subscriber, alert)
+
+	ticks := []int{}
+	Subscribe(controller.Events(), func(event TickEvent) {
+		ticks = append(ticks, event.Tick)
+	})
+
+	smq.SendMessage(SystemMessage{MessageType: alert, TTL: 1})
+	controller.Process([]reflect.Type{})
+	controller.Process([]reflect.Type{})
+
+	assert.Equal(t, []int{1, 2}, ticks)
+	assert.Empty(t, smq.GetSubscribedMessages(subscriber))
+}
+
+func TestSystemMessageQueuesSharingABus(t *testing.T) {
+	controller := NewController()
+	first := NewSystemMessageQueueOnBus(controller.Events())
+	second := NewSystemMessageQueueOnBus(controller.Events())
+
+	subscriber := &TestSystem{}
+	alert := SystemMessageType{Name: "alert"}
+	first.Subscribe(subscriber, alert)
+	second.Subscribe(subscriber, alert)
+
+	// Each queue only keeps its own messages
+	first.SendMessage(SystemMessage{MessageType: alert, MessageContent: map[string]string{"queue": "first"}})
+	assert.Equal(t, 1, len(first.Messages[subscriber]))
+	assert.Empty(t, second.Messages[subscriber])
+
+	// Closed queues stop receiving messages and ticks
+	second.Close()
+	second.Close()
+	second.SendMessage(SystemMessage{MessageType: alert})
+	assert.Empty(t, second.Messages[subscriber])
+
+	first.Close()
+	assert.Empty(t, controller.Events().handlers[reflect.TypeOf(SystemMessage{})])
+	assert.Empty(t, controller.Events().handlers[reflect.TypeOf(TickEvent{})])
+
+	controller.Process([]reflect.Type{})
+	assert.Equal(t, 0, first.tick)
+}
+
+type BlocksMovementComponent struct{}
+
+func (bc BlocksMovementComponent) TypeOf() reflect.Type {
+	return reflect.TypeOf(bc)
+}
+
+func TestSpatialIndex(t *testing.T) {
+	controller := NewController()
+
+	existing := controller.CreateEntity([]Component{PositionComponent{X: 1, Y: 1}})
+
+	index := NewSpatialIndexFor(controller, func(position PositionComponent) (int, int) {
+		return position.X, position.Y
+	})
+
+	// Entities that were positioned before the index was created are included
+	assert.Equal(t, []int{existing}, index.At(1, 1))
+
+	near := controller.CreateEntity([]Component{PositionComponent{X: 3, Y: 1}})
+	far := controller.CreateEntity([]Component{PositionComponent{X: 10, Y: 10}})
+	stacked := controller.CreateEntity([]Component{PositionComponent{X: 1, Y: 1}})
+	controller.CreateEntity([]Component{AppearanceComponent{}})
+
+	assert.Equal(t, []int{existing, stacked}, index.At(1, 1))
+	assert.Empty(t, index.At(2, 2))
+	assert.Equal(t, []int{existing, near, stacked}, index.InRect(0, 0, 4, 2))
+	assert.Equal(t, []int{existing, stacked}, index.InRect(0, 0, 3, 2))
+	assert.Equal(t, []int{existing, near, stacked}, index.InRadius(2, 2, 2))
+	assert.Equal(t, []int{existing, near, far, stacked}, index.InRadius(0, 0, 100))
+
+	// Moving, removing, and deleting are reflected in the index
+	controller.UpdateComponent(far, PositionComponent{}.TypeOf(), PositionComponent{X: 2, Y: 2})
+	assert.Equal(t, []int{far}, index.At(2, 2))
+	assert.Empty(t, index.At(10, 10))
+
+	x, y, ok := index.Position(far)
+	assert.True(t, ok)
+	assert.Equal(t, 2, x)
+	assert.Equal(t, 2, y)
+
+	controller.RemoveComponent(near, PositionComponent{}.TypeOf())
+	controller.DeleteEntity(stacked)
+	assert.Equal(t, []int{existing, far}, index.InRect(0, 0, 5, 5))
+
+	_, _, ok = index.Position(stacked)
+	assert.False(t, ok)
+}
+
+func TestSpatialIndexBlocking(t *testing.T) {
+	controller := NewController()
+	index := NewSpatialIndexFor(controller, func(position PositionComponent) (int, int) {
+		return position.X, position.Y
+	})
+
+	controller.CreateEntity([]Component{PositionComponent{X: 1, Y: 1}})
+	wall := controller.CreateEntity([]Component{PositionComponent{X: 2, Y: 2}, BlocksMovementComponent{}})
+
+	// Nothing blocks until a blocking component has been set
+	assert.False(t, index.IsBlocking(2, 2))
+
+	index.SetBlockingComponent(BlocksMovementComponent{}.TypeOf())
+	assert.True(t, index.IsBlocking(2, 2))
+	assert.False(t, index.IsBlocking(1, 1))
+	assert.False(t, index.IsBlocking(3, 3))
+
+	controller.RemoveComponent(wall, BlocksMovementComponent{}.TypeOf())
+	assert.False(t, index.IsBlocking(2, 2))
+}
@@ -0,0 +1,76 @@
+package ecs
+
+// EntityHandle is a versioned reference to an entity. Entity IDs are re-used by the Controller once an entity has been
+// deleted, so holding on to a bare ID (for example, as the target of an AI, or the source of a Dijkstra map) risks
+// silently referring to an unrelated entity created later on. A handle pairs the ID with the generation of the entity
+// at the time the handle was taken. Each time an ID is freed, its generation is incremented, so a stale handle can be
+// detected with Controller.IsAlive.
+type EntityHandle struct {
+	ID         int
+	Generation int
+}
+
+// GetHandle returns a versioned handle for an entity ID, based on the current generation of that ID
+func (c *Controller) GetHandle(entity int) EntityHandle {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return EntityHandle{ID: entity, Generation: c.generation(entity)}
+}
+
+// IsAlive returns true if the entity referenced by the handle still exists, and has not been deleted and replaced by a
+// newer entity re-using the same ID
+func (c *Controller) IsAlive(handle EntityHandle) bool {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	if _, ok := c.entities[handle.ID]; !ok {
+		return false
+	}
+
+	return c.generation(handle.ID) == handle.Generation
+}
+
+// generation returns the current generation of an entity ID. IDs that have never been handed out are generation 0.
+func (c *Controller) generation(entity int) int {
+	if entity >= 0 && entity < len(c.generations) {
+		return c.generations[entity]
+	}
+
+	return 0
+}
+
+// nextFreeEntity returns the ID to use for a newly created entity. IDs freed by DeleteEntity are re-used, oldest first,
+// before any new IDs are handed out.
+func (c *Controller) nextFreeEntity() int {
+	for len(c.deadEntities) > 0 {
+		entity := c.deadEntities[0]
+		c.deadEntities = c.deadEntities[1:]
+
+		// An entity can be brought back to life by adding a component directly to a freed ID. In that case, the ID is
+		// in use again, and can't be handed out.
+		if _, ok := c.entities[entity]; !ok {
+			return entity
+		}
+	}
+
+	entity := c.nextEntityID
+	c.nextEntityID++
+
+	for len(c.generations) <= entity {
+		c.generations = append(c.generations, 0)
+	}
+
+	return entity
+}
+
+// freeEntity marks an entity ID as available for re-use, and increments its generation so existing handles to it are
+// invalidated
+func (c *Controller) freeEntity(entity int) {
+	for len(c.generations) <= entity {
+		c.generations = append(c.generations, 0)
+	}
+
+	c.generations[entity]++
+	c.deadEntities = append(c.deadEntities, entity)
+}
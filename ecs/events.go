@@ -0,0 +1,141 @@
+package ecs
+
+import (
+	"reflect"
+	"sync"
+)
+
+// EventBus delivers typed events between systems (or any other part of the game). An event can be any Go value,
+// typically a struct, such as DamageEvent{Target: entity, Amount: 5}.
+// Handlers subscribe to a specific event type with Subscribe, and receive the event as that type, so there is no need
+// to encode event data as strings. Events can be delivered immediately with Publish, or queued with Enqueue, to be
+// delivered the next time Dispatch is called. The Controller owns an EventBus (see Controller.Events), and dispatches
+// its queued events at the start of each Process call. Handlers are called in the order they subscribed.
+type EventBus struct {
+	handlers map[reflect.Type][]eventHandler
+	queue    []queuedEvent
+	nextID   int
+	mutex    sync.Mutex
+}
+
+// eventHandler wraps a typed handler function, so handlers for every event type can be stored together
+type eventHandler struct {
+	id     int
+	handle func(event interface{})
+}
+
+// queuedEvent is an event waiting for the next Dispatch call, along with the type it was published as
+type queuedEvent struct {
+	eventType reflect.Type
+	event     interface{}
+}
+
+// TickEvent is published on a Controllers EventBus at the end of every Process call, once all systems have run and all
+// commands have been applied. Tick is the number of times Process has completed, starting at 1.
+type TickEvent struct {
+	Tick int
+}
+
+// Subscription identifies a single handler subscribed to an EventBus, so that it can be unsubscribed later
+type Subscription struct {
+	eventType reflect.Type
+	id        int
+}
+
+// NewEventBus creates a new EventBus with no subscribers
+func NewEventBus() *EventBus {
+	bus := EventBus{}
+	bus.handlers = make(map[reflect.Type][]eventHandler)
+	bus.queue = []queuedEvent{}
+
+	return &bus
+}
+
+// Subscribe registers a handler for events of type E. The returned Subscription can be passed to Unsubscribe to stop
+// receiving events.
+// Example:
+// ecs.Subscribe(bus, func(event DamageEvent) { ... })
+func Subscribe[E any](bus *EventBus, handler func(event E)) Subscription {
+	eventType := reflect.TypeOf((*E)(nil)).Elem()
+
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+
+	bus.nextID++
+	bus.handlers[eventType] = append(bus.handlers[eventType], eventHandler{
+		id: bus.nextID,
+		handle: func(event interface{}) {
+			handler(event.(E))
+		},
+	})
+
+	return Subscription{eventType: eventType, id: bus.nextID}
+}
+
+// Publish immediately delivers an event to every handler subscribed to its type. Handlers run before Publish returns.
+func Publish[E any](bus *EventBus, event E) {
+	bus.deliver(reflect.TypeOf((*E)(nil)).Elem(), event)
+}
+
+// Enqueue queues an event, to be delivered to every handler subscribed to its type the next time Dispatch is called
+func Enqueue[E any](bus *EventBus, event E) {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+
+	bus.queue = append(bus.queue, queuedEvent{eventType: reflect.TypeOf((*E)(nil)).Elem(), event: event})
+}
+
+// Unsubscribe removes a handler from the bus. Unsubscribing a handler that has already been removed does nothing.
+func (bus *EventBus) Unsubscribe(subscription Subscription) {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+
+	handlers := []eventHandler{}
+	for _, handler := range bus.handlers[subscription.eventType] {
+		if handler.id != subscription.id {
+			handlers = append(handlers, handler)
+		}
+	}
+
+	bus.handlers[subscription.eventType] = handlers
+}
+
+// Dispatch delivers every queued event, in the order they were queued. Events queued by handlers while dispatching
+// are held until the next call to Dispatch.
+func (bus *EventBus) Dispatch() {
+	bus.mutex.Lock()
+	queue := bus.queue
+	bus.queue = []queuedEvent{}
+	bus.mutex.Unlock()
+
+	for _, queued := range queue {
+		bus.deliver(queued.eventType, queued.event)
+	}
+}
+
+// Pending returns the number of events waiting to be dispatched
+func (bus *EventBus) Pending() int {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+
+	return len(bus.queue)
+}
+
+// deliver calls every handler subscribed to the event type. The handler list is copied first, so handlers are free to
+// subscribe, unsubscribe, or publish further events.
+func (bus *EventBus) deliver(eventType reflect.Type, event interface{}) {
+	bus.mutex.Lock()
+	handlers := make([]eventHandler, len(bus.handlers[eventType]))
+	copy(handlers, bus.handlers[eventType])
+	bus.mutex.Unlock()
+
+	for _, handler := range handlers {
+		handler.handle(event)
+	}
+}
+
+// Events returns the controllers EventBus. Events queued on it with Enqueue are delivered at the start of the next
+// Process call.
+func (c *Controller) Events() *EventBus {
+	return c.events
+}
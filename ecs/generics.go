@@ -0,0 +1,59 @@
+package ecs
+
+import "reflect"
+
+// ComponentType returns the reflect.Type a component of type T is stored under on the Controller, for use with the
+// reflection based API, ie ComponentType[PositionComponent]() is the same as reflect.TypeOf(PositionComponent{}).
+func ComponentType[T Component]() reflect.Type {
+	return reflect.TypeOf((*T)(nil)).Elem()
+}
+
+// Get returns the component of type T attached to an entity. The second return value is false if the entity does not
+// have a component of that type, in which case the zero value of T is returned.
+// Example:
+// position, ok := ecs.Get[PositionComponent](controller, entity)
+func Get[T Component](c *Controller, entity int) (T, bool) {
+	component, ok := c.GetComponent(entity, ComponentType[T]()).(T)
+	return component, ok
+}
+
+// Add attaches a component of type T to an entity. If the entity already has a component of that type, it is replaced.
+func Add[T Component](c *Controller, entity int, component T) {
+	c.AddComponent(entity, component)
+}
+
+// Has returns true if the entity has a component of type T attached
+func Has[T Component](c *Controller, entity int) bool {
+	return c.HasComponent(entity, ComponentType[T]())
+}
+
+// Remove removes the component of type T from an entity, if it has one
+func Remove[T Component](c *Controller, entity int) {
+	c.RemoveComponent(entity, ComponentType[T]())
+}
+
+// Each calls fn for every entity that has a component of type A, in ascending entity order
+func Each[A Component](c *Controller, fn func(entity int, a A)) {
+	for _, entity := range c.Query(NewQuery().With(ComponentType[A]())) {
+		// The callback may have removed the component from a later entity, so check it is still present
+		a, ok := Get[A](c, entity)
+		if ok {
+			fn(entity, a)
+		}
+	}
+}
+
+// Each2 calls fn for every entity that has both a component of type A and a component of type B, in ascending entity
+// order. It is backed by a cached Query, so it only visits entities that match.
+// Example:
+// ecs.Each2(controller, func(entity int, position PositionComponent, appearance AppearanceComponent) { ... })
+func Each2[A, B Component](c *Controller, fn func(entity int, a A, b B)) {
+	for _, entity := range c.Query(NewQuery().With(ComponentType[A](), ComponentType[B]())) {
+		// The callback may have removed components from a later entity, so check they are still present
+		a, okA := Get[A](c, entity)
+		b, okB := Get[B](c, entity)
+		if okA && okB {
+			fn(entity, a, b)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package ecs
+
+import (
+	"reflect"
+	"sort"
+)
+
+// ComponentObserver is notified when a component is attached to, or removed from, an entity. It receives the entity,
+// and the component instance that was added or removed.
+type ComponentObserver func(entity int, component Component)
+
+// ComponentUpdateObserver is notified when a component attached to an entity is replaced by a new instance of the same
+// component type. It receives the entity, the component instance that was replaced, and the new instance.
+type ComponentUpdateObserver func(entity int, previous, current Component)
+
+// EntityObserver is notified when an entity is deleted. It receives the ID of the deleted entity.
+type EntityObserver func(entity int)
+
+// observers holds all observers registered on a Controller. Observers for each event are called in the order they were
+// registered.
+type observers struct {
+	onAdd           map[reflect.Type][]ComponentObserver
+	onRemove        map[reflect.Type][]ComponentObserver
+	onUpdate        map[reflect.Type][]ComponentUpdateObserver
+	onEntityDeleted []EntityObserver
+}
+
+// newObservers initializes an empty set of observers
+func newObservers() observers {
+	return observers{
+		onAdd:           make(map[reflect.Type][]ComponentObserver),
+		onRemove:        make(map[reflect.Type][]ComponentObserver),
+		onUpdate:        make(map[reflect.Type][]ComponentUpdateObserver),
+		onEntityDeleted: []EntityObserver{},
+	}
+}
+
+// OnAdd registers an observer that is called any time a component of the given type is attached to an entity that did
+// not already have one, either via AddComponent, CreateEntity, or UpdateComponent. The observer is called after the
+// component has been attached.
+func (c *Controller) OnAdd(componentType reflect.Type, observer ComponentObserver) {
+	c.observers.onAdd[componentType] = append(c.observers.onAdd[componentType], observer)
+}
+
+// OnRemove registers an observer that is called any time a component of the given type is removed from an entity,
+// either via RemoveComponent, or because the entity was deleted. The observer is called after the component has been
+// removed.
+func (c *Controller) OnRemove(componentType reflect.Type, observer ComponentObserver) {
+	c.observers.onRemove[componentType] = append(c.observers.onRemove[componentType], observer)
+}
+
+// OnUpdate registers an observer that is called any time a component of the given type is replaced with a new instance
+// on an entity, via UpdateComponent, or by calling AddComponent for a component type the entity already has. The
+// observer is called after the new component has been stored.
+func (c *Controller) OnUpdate(componentType reflect.Type, observer ComponentUpdateObserver) {
+	c.observers.onUpdate[componentType] = append(c.observers.onUpdate[componentType], observer)
+}
+
+// OnEntityDeleted registers an observer that is called any time an entity is deleted. OnRemove observers for each of
+// the entities components are called first. The observer is called before the entity ID is freed up for re-use.
+func (c *Controller) OnEntityDeleted(observer EntityObserver) {
+	c.observers.onEntityDeleted = append(c.observers.onEntityDeleted, observer)
+}
+
+// notifyAdd calls all OnAdd observers registered for the type of component
+func (c *Controller) notifyAdd(entity int, componentType reflect.Type, component Component) {
+	for _, observer := range c.observers.onAdd[componentType] {
+		observer(entity, component)
+	}
+}
+
+// notifyRemove calls all OnRemove observers registered for the type of component
+func (c *Controller) notifyRemove(entity int, componentType reflect.Type, component Component) {
+	for _, observer := range c.observers.onRemove[componentType] {
+		observer(entity, component)
+	}
+}
+
+// notifyUpdate calls all OnUpdate observers registered for the type of component
+func (c *Controller) notifyUpdate(entity int, componentType reflect.Type, previous, current Component) {
+	for _, observer := range c.observers.onUpdate[componentType] {
+		observer(entity, previous, current)
+	}
+}
+
+// notifyEntityDeleted calls all OnEntityDeleted observers
+func (c *Controller) notifyEntityDeleted(entity int) {
+	for _, observer := range c.observers.onEntityDeleted {
+		observer(entity)
+	}
+}
+
+// sortedComponentTypes returns the component types attached to an entity, sorted by name. This gives a stable order
+// for operations that touch every component on an entity, so any observers they trigger are called in the same order
+// each time.
+func sortedComponentTypes(components map[reflect.Type]Component) []reflect.Type {
+	componentTypes := make([]reflect.Type, 0, len(components))
+	for componentType := range components {
+		componentTypes = append(componentTypes, componentType)
+	}
+
+	sort.Slice(componentTypes, func(i, j int) bool {
+		return componentTypes[i].String() < componentTypes[j].String()
+	})
+
+	return componentTypes
+}
@@ -0,0 +1,179 @@
+package ecs
+
+import (
+	"bytes"
+	"reflect"
+	"runtime"
+	"strconv"
+	"sync"
+)
+
+// Reads declares the component types a system reads. Together with Writes, this allows the controller to run systems
+// that do not touch the same data at the same time. A system that declares neither is assumed to access everything,
+// and always runs on its own.
+func Reads(componentTypes ...reflect.Type) SystemOption {
+	return func(entry *systemEntry) {
+		entry.reads = append(entry.reads, componentTypes...)
+		entry.declaresAccess = true
+	}
+}
+
+// Writes declares the component types a system modifies (adds, updates or removes). A system that writes a component
+// type never runs at the same time as another system that reads or writes it.
+func Writes(componentTypes ...reflect.Type) SystemOption {
+	return func(entry *systemEntry) {
+		entry.writes = append(entry.writes, componentTypes...)
+		entry.declaresAccess = true
+	}
+}
+
+// SetWorkers sets the number of goroutines the controller uses to process systems. With more than one worker, systems
+// in the same priority group that have declared their component access (via Reads and Writes), and do not conflict
+// with each other, are processed concurrently. Systems are split into stages, following their normal processing order:
+// a new stage is started whenever the next system conflicts with, or depends on, a system already in the current
+// stage. Stages run one after another, so conflicting systems still run in the same order they would serially.
+// The default is one worker, which processes every system serially.
+// Each systems commands are applied in processing order once its stage is complete, so the results are the same
+// regardless of which system finished first. Commands recorded on the controllers CommandBuffer (via Commands) from
+// within a systems Process are treated as if they had been recorded on the systems own buffer (see CommandsFor). Only
+// commands recorded from other goroutines a system starts itself are applied in the order they arrive.
+func (c *Controller) SetWorkers(workers int) {
+	if workers < 1 {
+		workers = 1
+	}
+
+	c.workers = workers
+}
+
+// CommandsFor returns the CommandBuffer belonging to the named system. Commands recorded on it are moved to the
+// controllers CommandBuffer as soon as the system (or, when processing in parallel, the stage the system is part of)
+// has finished, in the order the systems are processed. If no system with the name is registered, the controllers
+// CommandBuffer is returned.
+func (c *Controller) CommandsFor(name string) *CommandBuffer {
+	if entry, ok := c.systems[name]; ok {
+		return entry.commands
+	}
+
+	return c.commands
+}
+
+// conflictsWith returns true if two systems can not safely be processed at the same time, either because they access
+// the same component types, and at least one of them writes to it, or because one must run before the other.
+func (entry *systemEntry) conflictsWith(other *systemEntry) bool {
+	if !entry.declaresAccess || !other.declaresAccess {
+		return true
+	}
+
+	for _, componentType := range entry.writes {
+		if TypeInSlice(componentType, other.reads) || TypeInSlice(componentType, other.writes) {
+			return true
+		}
+	}
+
+	for _, componentType := range other.writes {
+		if TypeInSlice(componentType, entry.reads) {
+			return true
+		}
+	}
+
+	return stringInSlice(other.name, entry.before) || stringInSlice(other.name, entry.after) ||
+		stringInSlice(entry.name, other.before) || stringInSlice(entry.name, other.after)
+}
+
+// buildStages splits an ordered list of systems into stages of systems that can be processed at the same time. Each
+// system is added to the current stage, unless it conflicts with a system already in it, in which case a new stage is
+// started.
+func buildStages(entries []*systemEntry) [][]*systemEntry {
+	stages := [][]*systemEntry{}
+	stage := []*systemEntry{}
+
+	for _, entry := range entries {
+		for _, staged := range stage {
+			if entry.conflictsWith(staged) {
+				stages = append(stages, stage)
+				stage = []*systemEntry{}
+				break
+			}
+		}
+
+		stage = append(stage, entry)
+	}
+
+	if len(stage) > 0 {
+		stages = append(stages, stage)
+	}
+
+	return stages
+}
+
+// processStage processes every system in a stage, using the controllers worker pool if there is more than one system,
+// and then moves each systems recorded commands to the controllers CommandBuffer, in order.
+func (c *Controller) processStage(stage []*systemEntry) {
+	if len(stage) == 1 || c.workers == 1 {
+		for _, entry := range stage {
+			entry.system.Process()
+			c.commands.appendFrom(entry.commands)
+		}
+
+		return
+	}
+
+	workers := c.workers
+	if workers > len(stage) {
+		workers = len(stage)
+	}
+
+	jobs := make(chan *systemEntry)
+	var waitGroup sync.WaitGroup
+
+	for i := 0; i < workers; i++ {
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+
+			// Commands recorded on the controllers CommandBuffer by the system are moved to its own buffer, so they are
+			// applied in processing order along with the rest of the systems commands
+			routine := goroutineID()
+			for entry := range jobs {
+				c.commands.route(routine, entry.commands)
+				entry.system.Process()
+				c.commands.route(routine, nil)
+			}
+		}()
+	}
+
+	for _, entry := range stage {
+		jobs <- entry
+	}
+	close(jobs)
+	waitGroup.Wait()
+
+	for _, entry := range stage {
+		c.commands.appendFrom(entry.commands)
+	}
+}
+
+// goroutineID returns the ID of the current goroutine, as shown at the top of its stack trace
+func goroutineID() uint64 {
+	buffer := make([]byte, 64)
+	buffer = buffer[:runtime.Stack(buffer, false)]
+
+	// The trace starts with "goroutine <id> [running]:"
+	buffer = bytes.TrimPrefix(buffer, []byte("goroutine "))
+	if end := bytes.IndexByte(buffer, ' '); end >= 0 {
+		buffer = buffer[:end]
+	}
+
+	id, _ := strconv.ParseUint(string(buffer), 10, 64)
+	return id
+}
+
+// stringInSlice will return true if the string provided is present in the slice provided, false otherwise.
+func stringInSlice(a string, list []string) bool {
+	for _, b := range list {
+		if b == a {
+			return true
+		}
+	}
+	return false
+}
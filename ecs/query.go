@@ -0,0 +1,202 @@
+package ecs
+
+import (
+	"reflect"
+	"sort"
+)
+
+// Query describes a set of entities based on the components attached to them. An entity matches a query if it has
+// every component listed in With, none of the components listed in Without, and (if any are listed) at least one of the
+// components listed in Any. Queries are immutable: each filter method returns a new Query, so a query can safely be
+// built once and reused every frame.
+// Example:
+// visible := ecs.NewQuery().With(PositionComponent{}.TypeOf(), RenderableComponent{}.TypeOf()).Without(ParalyzedComponent{}.TypeOf())
+// entities := controller.Query(visible)
+type Query struct {
+	with    []reflect.Type
+	without []reflect.Type
+	any     []reflect.Type
+}
+
+// NewQuery creates an empty Query. An empty query matches every entity known to the controller.
+func NewQuery() Query {
+	return Query{}
+}
+
+// With returns a copy of the query that additionally requires all of the given component types to be present
+func (q Query) With(componentTypes ...reflect.Type) Query {
+	q.with = appendTypes(q.with, componentTypes)
+	return q
+}
+
+// Without returns a copy of the query that additionally requires none of the given component types to be present
+func (q Query) Without(componentTypes ...reflect.Type) Query {
+	q.without = appendTypes(q.without, componentTypes)
+	return q
+}
+
+// Any returns a copy of the query that additionally requires at least one of the given component types to be present
+func (q Query) Any(componentTypes ...reflect.Type) Query {
+	q.any = appendTypes(q.any, componentTypes)
+	return q
+}
+
+// Matches returns true if an entity with the given set of components satisfies the query
+func (q Query) Matches(components map[reflect.Type]Component) bool {
+	for _, componentType := range q.with {
+		if _, ok := components[componentType]; !ok {
+			return false
+		}
+	}
+
+	for _, componentType := range q.without {
+		if _, ok := components[componentType]; ok {
+			return false
+		}
+	}
+
+	if len(q.any) == 0 {
+		return true
+	}
+
+	for _, componentType := range q.any {
+		if _, ok := components[componentType]; ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// equals checks if two queries describe the same set of filters, regardless of the order the filters were provided in
+func (q Query) equals(other Query) bool {
+	return sameTypes(q.with, other.with) && sameTypes(q.without, other.without) && sameTypes(q.any, other.any)
+}
+
+// queryView is a cached result set for a single Query. The controller keeps each view up to date as components are
+// added and removed, so reading a view only costs as much as the number of entities it contains. Entities are kept
+// sorted, so results are returned in a stable order.
+type queryView struct {
+	query    Query
+	entities []int
+}
+
+// update adds or removes an entity from the view, based on whether the entities current components match the query
+func (qv *queryView) update(entity int, components map[reflect.Type]Component, exists bool) {
+	if exists && qv.query.Matches(components) {
+		qv.insert(entity)
+	} else {
+		qv.remove(entity)
+	}
+}
+
+// insert adds an entity to the view, keeping the entity list sorted. Entities already in the view are ignored.
+func (qv *queryView) insert(entity int) {
+	index := sort.SearchInts(qv.entities, entity)
+	if index < len(qv.entities) && qv.entities[index] == entity {
+		return
+	}
+
+	qv.entities = append(qv.entities, 0)
+	copy(qv.entities[index+1:], qv.entities[index:])
+	qv.entities[index] = entity
+}
+
+// remove deletes an entity from the view, if it is present
+func (qv *queryView) remove(entity int) {
+	index := sort.SearchInts(qv.entities, entity)
+	if index < len(qv.entities) && qv.entities[index] == entity {
+		qv.entities = append(qv.entities[:index], qv.entities[index+1:]...)
+	}
+}
+
+// Query returns a list of all entities that match the provided query, in ascending order. The first time a query is
+// run, the controller builds a cached view for it by checking every entity. From then on, the view is maintained
+// incrementally by AddComponent, RemoveComponent, CreateEntity, and DeleteEntity, so subsequent calls only cost as much
+// as the number of matching entities. The returned slice is a copy, and is safe to hold on to while modifying entities.
+func (c *Controller) Query(query Query) []int {
+	c.mutex.RLock()
+	view := c.findView(query)
+	if view != nil {
+		defer c.mutex.RUnlock()
+		return copyEntities(view.entities)
+	}
+	c.mutex.RUnlock()
+
+	// There is no view for this query yet, so one needs to be built
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return copyEntities(c.getView(query).entities)
+}
+
+// findView returns the cached view for a query, or nil if there isn't one yet
+func (c *Controller) findView(query Query) *queryView {
+	for _, view := range c.views {
+		if view.query.equals(query) {
+			return view
+		}
+	}
+
+	return nil
+}
+
+// getView returns the cached view for a query, creating and populating it if it does not exist yet
+func (c *Controller) getView(query Query) *queryView {
+	if view := c.findView(query); view != nil {
+		return view
+	}
+
+	view := &queryView{query: query, entities: []int{}}
+	for entity, components := range c.entities {
+		if query.Matches(components) {
+			view.entities = append(view.entities, entity)
+		}
+	}
+	sort.Ints(view.entities)
+
+	c.views = append(c.views, view)
+
+	return view
+}
+
+// updateViews re-evaluates an entity against every cached view. This should be called any time the set of components
+// attached to an entity changes, or the entity is created or destroyed.
+func (c *Controller) updateViews(entity int) {
+	components, exists := c.entities[entity]
+	for _, view := range c.views {
+		view.update(entity, components, exists)
+	}
+}
+
+// copyEntities returns a copy of a list of entities
+func copyEntities(entities []int) []int {
+	entitiesCopy := make([]int, len(entities))
+	copy(entitiesCopy, entities)
+
+	return entitiesCopy
+}
+
+// appendTypes returns a new slice containing the types from both lists, leaving the original list untouched
+func appendTypes(list []reflect.Type, types []reflect.Type) []reflect.Type {
+	newList := make([]reflect.Type, 0, len(list)+len(types))
+	newList = append(newList, list...)
+	return append(newList, types...)
+}
+
+// sameTypes returns true if both lists contain the same set of types
+func sameTypes(a, b []reflect.Type) bool {
+	for _, componentType := range a {
+		if !TypeInSlice(componentType, b) {
+			return false
+		}
+	}
+
+	for _, componentType := range b {
+		if !TypeInSlice(componentType, a) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,228 @@
+package ecs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+)
+
+// snapshotVersion is the version of the save format written by Controller.Save. It should be incremented any time the
+// format changes in a way that older versions of Load would not understand.
+const snapshotVersion = 1
+
+// worldSnapshot is the serialized form of a Controller. Components are stored under the names they were registered
+// with via MapComponentClass, so the save format does not depend on Go type names, which may change between builds.
+type worldSnapshot struct {
+	Version      int              `json:"version"`
+	NextEntityID int              `json:"next_entity_id"`
+	Generations  []int            `json:"generations"`
+	DeadEntities []int            `json:"dead_entities"`
+	Entities     []entitySnapshot `json:"entities"`
+	Systems      []systemSnapshot `json:"systems"`
+}
+
+// entitySnapshot is the serialized form of a single entity, and all of its components
+type entitySnapshot struct {
+	ID         int                        `json:"id"`
+	Components map[string]json.RawMessage `json:"components"`
+}
+
+// systemSnapshot records a registered system, so that the order systems are processed in can be restored
+type systemSnapshot struct {
+	Name     string `json:"name"`
+	Priority int    `json:"priority"`
+}
+
+// Save writes a versioned JSON snapshot of the controller to w. The snapshot contains every entity, all of their
+// components, the entity ID bookkeeping, and the order systems are registered in. Systems themselves are not saved, as
+// they are code rather than data. Every component attached to an entity must have been registered with
+// MapComponentClass, and must be able to round trip through encoding/json (components with interface fields, for
+// example, should implement json.Marshaler and json.Unmarshaler). If an unregistered component is found, an error is
+// returned and nothing is written.
+func (c *Controller) Save(w io.Writer) error {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	snapshot := worldSnapshot{
+		Version:      snapshotVersion,
+		NextEntityID: c.nextEntityID,
+		Generations:  c.generations,
+		DeadEntities: c.deadEntities,
+		Entities:     []entitySnapshot{},
+		Systems:      []systemSnapshot{},
+	}
+
+	componentNames := c.componentNames()
+
+	entities := make([]int, 0, len(c.entities))
+	for entity := range c.entities {
+		entities = append(entities, entity)
+	}
+	sort.Ints(entities)
+
+	for _, entity := range entities {
+		entitySnap := entitySnapshot{ID: entity, Components: make(map[string]json.RawMessage)}
+
+		for componentType, component := range c.entities[entity] {
+			name, ok := componentNames[componentType]
+			if !ok {
+				return fmt.Errorf("component type %v on entity %d is not registered on the controller; register it with MapComponentClass before saving", componentType, entity)
+			}
+
+			data, err := json.Marshal(component)
+			if err != nil {
+				return fmt.Errorf("could not encode component %q on entity %d: %w", name, entity, err)
+			}
+
+			entitySnap.Components[name] = data
+		}
+
+		snapshot.Entities = append(snapshot.Entities, entitySnap)
+	}
+
+	for _, priority := range c.priorityKeys {
+		for _, entry := range c.sortedSystems[priority] {
+			snapshot.Systems = append(snapshot.Systems, systemSnapshot{Name: entry.name, Priority: priority})
+		}
+	}
+
+	encoder := json.NewEncoder(w)
+	return encoder.Encode(snapshot)
+}
+
+// Load restores a snapshot written by Save into the controller. The controller must not contain any entities yet, and
+// should have the same components (via MapComponentClass) and systems (via AddSystem or AddNamedSystem) registered as
+// the controller that was saved. Systems are re-ordered to match the order they were processed in when the snapshot
+// was taken. If the snapshot cannot be decoded, or references a component or system that is not registered, an error
+// is returned, and the controller is left unchanged.
+// OnAdd observers are called for every restored component, but only once every entity has been restored, so they
+// never see a partially loaded world.
+func (c *Controller) Load(r io.Reader) error {
+	if len(c.entities) > 0 {
+		return fmt.Errorf("cannot load a snapshot into a controller that already contains %d entities", len(c.entities))
+	}
+
+	var snapshot worldSnapshot
+
+	decoder := json.NewDecoder(r)
+	if err := decoder.Decode(&snapshot); err != nil {
+		return fmt.Errorf("could not decode snapshot: %w", err)
+	}
+
+	if snapshot.Version != snapshotVersion {
+		return fmt.Errorf("unsupported snapshot version %d, expected %d", snapshot.Version, snapshotVersion)
+	}
+
+	// Decode every component before touching the controller, so a bad snapshot doesn't leave a half loaded world
+	entities := make(map[int][]Component)
+	for _, entitySnap := range snapshot.Entities {
+		names := make([]string, 0, len(entitySnap.Components))
+		for name := range entitySnap.Components {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		components := []Component{}
+		for _, name := range names {
+			component, err := c.decodeComponent(name, entitySnap.Components[name])
+			if err != nil {
+				return fmt.Errorf("entity %d: %w", entitySnap.ID, err)
+			}
+
+			components = append(components, component)
+		}
+
+		entities[entitySnap.ID] = components
+	}
+
+	if err := c.restoreSystemOrder(snapshot.Systems); err != nil {
+		return err
+	}
+
+	// Restore the whole world first, and only then tell the observers about it
+	c.mutex.Lock()
+	c.nextEntityID = snapshot.NextEntityID
+	c.generations = append([]int{}, snapshot.Generations...)
+	c.deadEntities = append([]int{}, snapshot.DeadEntities...)
+
+	for _, entitySnap := range snapshot.Entities {
+		c.entities[entitySnap.ID] = make(map[reflect.Type]Component)
+
+		for _, component := range entities[entitySnap.ID] {
+			componentType := reflect.TypeOf(component)
+			c.components[componentType] = append(c.components[componentType], entitySnap.ID)
+			c.entities[entitySnap.ID][componentType] = component
+		}
+
+		c.updateViews(entitySnap.ID)
+	}
+	c.mutex.Unlock()
+
+	for _, entitySnap := range snapshot.Entities {
+		for _, component := range entities[entitySnap.ID] {
+			c.notifyAdd(entitySnap.ID, reflect.TypeOf(component), component)
+		}
+	}
+
+	return nil
+}
+
+// componentNames builds a reverse lookup of the component map, from component type to registered name. If a type has
+// been registered under several names, the alphabetically first name is used, so saves are stable.
+func (c *Controller) componentNames() map[reflect.Type]string {
+	names := make(map[reflect.Type]string)
+
+	for name, component := range c.componentMap {
+		componentType := reflect.TypeOf(component)
+		if existing, ok := names[componentType]; !ok || name < existing {
+			names[componentType] = name
+		}
+	}
+
+	return names
+}
+
+// decodeComponent creates a new instance of the component registered under name, and fills it in from its JSON form
+func (c *Controller) decodeComponent(name string, data json.RawMessage) (Component, error) {
+	registered, ok := c.componentMap[name]
+	if !ok {
+		return nil, fmt.Errorf("component %q is not registered on the controller; register it with MapComponentClass before loading", name)
+	}
+
+	value := reflect.New(reflect.TypeOf(registered))
+	if err := json.Unmarshal(data, value.Interface()); err != nil {
+		return nil, fmt.Errorf("could not decode component %q: %w", name, err)
+	}
+
+	return value.Elem().Interface().(Component), nil
+}
+
+// restoreSystemOrder re-orders the registered systems so that systems recorded in a snapshot are processed in the same
+// order they were when it was saved. This is done by replaying the snapshot order as the registration order, which
+// the dependency sort uses to break ties. Systems registered on the controller, but not present in the snapshot, keep
+// their current relative order, and are treated as registered after the restored systems.
+func (c *Controller) restoreSystemOrder(systems []systemSnapshot) error {
+	for _, systemSnap := range systems {
+		entry, ok := c.systems[systemSnap.Name]
+		if !ok || entry.priority != systemSnap.Priority {
+			return fmt.Errorf("system %s with priority %d is not registered on the controller; register it before loading", systemSnap.Name, systemSnap.Priority)
+		}
+	}
+
+	restored := make(map[string]bool)
+	for i, systemSnap := range systems {
+		c.systems[systemSnap.Name].order = i
+		restored[systemSnap.Name] = true
+	}
+
+	for _, entry := range c.systems {
+		if !restored[entry.name] {
+			entry.order += len(systems)
+		}
+	}
+	c.nextSystemOrder += len(systems)
+
+	return c.sortSystems()
+}
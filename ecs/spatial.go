@@ -0,0 +1,220 @@
+package ecs
+
+import (
+	"reflect"
+	"sort"
+	"sync"
+)
+
+// PositionFunc extracts a position from a position component, so that a SpatialIndex can work with whatever position
+// component a game defines
+type PositionFunc func(component Component) (x, y int)
+
+// cell is a single location in a SpatialIndex
+type cell struct {
+	x int
+	y int
+}
+
+// SpatialIndex keeps track of where entities are, based on a position component, so that entities can be looked up by
+// location without checking every entity. The index registers observers on the Controller, and is kept in sync
+// whenever a position component is added, replaced, or removed (including when an entity is deleted). Position
+// components must be replaced (via AddComponent or UpdateComponent) to move an entity; changing the fields of a
+// component pointer in place is not seen by the index.
+type SpatialIndex struct {
+	controller   *Controller
+	positionType reflect.Type
+	position     PositionFunc
+	blockingType reflect.Type
+	cells        map[cell][]int
+	locations    map[int]cell
+	mutex        sync.RWMutex
+}
+
+// NewSpatialIndex creates a SpatialIndex of every entity with a component of positionType, using position to read the
+// location from the component. Entities that already have a position component are indexed straight away.
+// Example:
+// index := controller.NewSpatialIndex(PositionComponent{}.TypeOf(), func(c Component) (int, int) { p := c.(PositionComponent); return p.X, p.Y })
+func (c *Controller) NewSpatialIndex(positionType reflect.Type, position PositionFunc) *SpatialIndex {
+	index := SpatialIndex{}
+	index.controller = c
+	index.positionType = positionType
+	index.position = position
+	index.cells = make(map[cell][]int)
+	index.locations = make(map[int]cell)
+
+	c.OnAdd(positionType, func(entity int, component Component) {
+		index.move(entity, component)
+	})
+	c.OnUpdate(positionType, func(entity int, previous, current Component) {
+		index.move(entity, current)
+	})
+	c.OnRemove(positionType, func(entity int, component Component) {
+		index.remove(entity)
+	})
+
+	for _, entity := range c.Query(NewQuery().With(positionType)) {
+		if component := c.GetComponent(entity, positionType); component != nil {
+			index.move(entity, component)
+		}
+	}
+
+	return &index
+}
+
+// NewSpatialIndexFor is the generic equivalent of Controller.NewSpatialIndex, for a position component of type T
+// Example:
+// index := ecs.NewSpatialIndexFor(controller, func(p PositionComponent) (int, int) { return p.X, p.Y })
+func NewSpatialIndexFor[T Component](c *Controller, position func(component T) (x, y int)) *SpatialIndex {
+	return c.NewSpatialIndex(ComponentType[T](), func(component Component) (int, int) {
+		return position(component.(T))
+	})
+}
+
+// SetBlockingComponent sets the component type that marks an entity as blocking the tile it stands on (see
+// IsBlocking). Without a blocking component, no entity blocks.
+func (si *SpatialIndex) SetBlockingComponent(componentType reflect.Type) {
+	si.mutex.Lock()
+	defer si.mutex.Unlock()
+
+	si.blockingType = componentType
+}
+
+// IsBlocking returns true if any entity at the given location has the blocking component. This satisfies the Blocker
+// interface used by gamemap.GameMap, so a SpatialIndex can be used to take entities into account in GameMap.IsBlocked.
+func (si *SpatialIndex) IsBlocking(x, y int) bool {
+	si.mutex.RLock()
+	blockingType := si.blockingType
+	entities := copyEntities(si.cells[cell{x, y}])
+	si.mutex.RUnlock()
+
+	if blockingType == nil {
+		return false
+	}
+
+	for _, entity := range entities {
+		if si.controller.HasComponent(entity, blockingType) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Position returns the location of an entity in the index. The last return value is false if the entity is not in the
+// index.
+func (si *SpatialIndex) Position(entity int) (int, int, bool) {
+	si.mutex.RLock()
+	defer si.mutex.RUnlock()
+
+	location, ok := si.locations[entity]
+	return location.x, location.y, ok
+}
+
+// At returns every entity at the given location, in ascending entity order
+func (si *SpatialIndex) At(x, y int) []int {
+	si.mutex.RLock()
+	defer si.mutex.RUnlock()
+
+	return copyEntities(si.cells[cell{x, y}])
+}
+
+// InRect returns every entity within the rectangle with its top left corner at (x, y), and the given width and height,
+// in ascending entity order
+func (si *SpatialIndex) InRect(x, y, width, height int) []int {
+	return si.collect(x, y, x+width-1, y+height-1, func(location cell) bool {
+		return true
+	})
+}
+
+// InRadius returns every entity within radius tiles of (x, y), in ascending entity order. Distance is measured as a
+// straight line, so the area covered is a circle.
+func (si *SpatialIndex) InRadius(x, y, radius int) []int {
+	return si.collect(x-radius, y-radius, x+radius, y+radius, func(location cell) bool {
+		dx, dy := location.x-x, location.y-y
+		return dx*dx+dy*dy <= radius*radius
+	})
+}
+
+// collect returns every entity within the given bounds (inclusive) whose location passes the filter, in ascending
+// entity order. Small areas are checked cell by cell, large ones by checking the location of every entity instead.
+func (si *SpatialIndex) collect(minX, minY, maxX, maxY int, filter func(location cell) bool) []int {
+	si.mutex.RLock()
+	defer si.mutex.RUnlock()
+
+	entities := []int{}
+
+	if maxX < minX || maxY < minY {
+		return entities
+	}
+
+	if (maxX-minX+1)*(maxY-minY+1) <= len(si.cells) {
+		for x := minX; x <= maxX; x++ {
+			for y := minY; y <= maxY; y++ {
+				location := cell{x, y}
+				if filter(location) {
+					entities = append(entities, si.cells[location]...)
+				}
+			}
+		}
+	} else {
+		for entity, location := range si.locations {
+			if location.x >= minX && location.x <= maxX && location.y >= minY && location.y <= maxY && filter(location) {
+				entities = append(entities, entity)
+			}
+		}
+	}
+
+	sort.Ints(entities)
+	return entities
+}
+
+// move places an entity at the location held by its position component
+func (si *SpatialIndex) move(entity int, component Component) {
+	x, y := si.position(component)
+
+	si.mutex.Lock()
+	defer si.mutex.Unlock()
+
+	si.removeLocked(entity)
+
+	location := cell{x, y}
+	entities := si.cells[location]
+	index := sort.SearchInts(entities, entity)
+	entities = append(entities, 0)
+	copy(entities[index+1:], entities[index:])
+	entities[index] = entity
+
+	si.cells[location] = entities
+	si.locations[entity] = location
+}
+
+// remove takes an entity out of the index
+func (si *SpatialIndex) remove(entity int) {
+	si.mutex.Lock()
+	defer si.mutex.Unlock()
+
+	si.removeLocked(entity)
+}
+
+// removeLocked takes an entity out of the index. The index lock must be held.
+func (si *SpatialIndex) removeLocked(entity int) {
+	location, ok := si.locations[entity]
+	if !ok {
+		return
+	}
+
+	entities := si.cells[location]
+	index := sort.SearchInts(entities, entity)
+	if index < len(entities) && entities[index] == entity {
+		entities = append(entities[:index], entities[index+1:]...)
+	}
+
+	if len(entities) == 0 {
+		delete(si.cells, location)
+	} else {
+		si.cells[location] = entities
+	}
+
+	delete(si.locations, entity)
+}
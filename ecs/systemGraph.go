@@ -0,0 +1,269 @@
+package ecs
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// systemEntry holds a registered system, along with everything the controller needs to know to decide when to run it
+type systemEntry struct {
+	name     string
+	system   System
+	priority int
+	order    int
+	before   []string
+	after    []string
+	groups   []string
+	enabled  bool
+	commands *CommandBuffer
+
+	// Component access, used to decide which systems can be processed in parallel
+	reads          []reflect.Type
+	writes         []reflect.Type
+	declaresAccess bool
+}
+
+// SystemOption configures a system as it is registered with AddNamedSystem
+type SystemOption func(entry *systemEntry)
+
+// Before declares that a system must run before the named systems. Dependencies only order systems within the same
+// priority group; naming a system with a lower priority is an error, as the priorities already require it to run first.
+// Systems that have not been registered (yet) are ignored.
+func Before(names ...string) SystemOption {
+	return func(entry *systemEntry) {
+		entry.before = append(entry.before, names...)
+	}
+}
+
+// After declares that a system must run after the named systems. Dependencies only order systems within the same
+// priority group; naming a system with a higher priority is an error, as the priorities already require it to run
+// last. Systems that have not been registered (yet) are ignored.
+func After(names ...string) SystemOption {
+	return func(entry *systemEntry) {
+		entry.after = append(entry.after, names...)
+	}
+}
+
+// InGroup adds a system to one or more named groups, such as "world" or "ui". All systems in a group can be enabled or
+// disabled together with SetGroupEnabled, which is useful for pausing the game world while a menu or inventory screen
+// is open.
+func InGroup(groups ...string) SystemOption {
+	return func(entry *systemEntry) {
+		entry.groups = append(entry.groups, groups...)
+	}
+}
+
+// AddNamedSystem registers a system with the controller under a unique name. Unlike AddSystem, several systems of the
+// same type can be registered, as long as they have different names. Systems are processed in priority order, low to
+// high. Within a priority group, systems are ordered so that any Before and After dependencies are satisfied, and any
+// remaining ties are broken by the order the systems were registered in, so processing order is the same on every run.
+// An error is returned if the name is already in use, or if the dependencies can't be satisfied (for example, if they
+// form a cycle). In that case, the system is not registered.
+func (c *Controller) AddNamedSystem(name string, system System, priority int, options ...SystemOption) error {
+	if _, ok := c.systems[name]; ok {
+		return fmt.Errorf("a system named %q was already added to the controller", name)
+	}
+
+	entry := &systemEntry{name: name, system: system, priority: priority, order: c.nextSystemOrder, enabled: true}
+	entry.commands = newCommandBuffer(c)
+	for _, option := range options {
+		option(entry)
+	}
+
+	c.systems[name] = entry
+	if err := c.sortSystems(); err != nil {
+		// Roll back the registration. The previous set of systems was valid, so re-sorting it can't fail.
+		delete(c.systems, name)
+		c.sortSystems()
+		return err
+	}
+
+	c.nextSystemOrder++
+
+	return nil
+}
+
+// HasNamedSystem checks the controller to see if it has a system registered under the given name
+func (c *Controller) HasNamedSystem(name string) bool {
+	_, ok := c.systems[name]
+	return ok
+}
+
+// ProcessNamedSystem allows for on demand processing of a single system, by name. The system is run even if it, or
+// one of its groups, is disabled. Any commands recorded by the system are applied once it has finished.
+func (c *Controller) ProcessNamedSystem(name string) {
+	if entry, ok := c.systems[name]; ok {
+		c.processStage([]*systemEntry{entry})
+		c.FlushCommands()
+	}
+}
+
+// RemoveSystem unregisters the system with the given name. Systems added with AddSystem are named after their type,
+// for example "*game.MovementSystem". Any dependencies other systems declared on the removed system are ignored from
+// then on. An error is returned if no system with that name is registered.
+func (c *Controller) RemoveSystem(name string) error {
+	if _, ok := c.systems[name]; !ok {
+		return fmt.Errorf("no system named %q is registered on the controller", name)
+	}
+
+	delete(c.systems, name)
+
+	// Removing a system only ever removes constraints, so the remaining systems can always be sorted
+	return c.sortSystems()
+}
+
+// SetSystemEnabled enables or disables a single system. Disabled systems stay registered, and keep their place in the
+// processing order, but are skipped by Process. An error is returned if no system with that name is registered.
+func (c *Controller) SetSystemEnabled(name string, enabled bool) error {
+	entry, ok := c.systems[name]
+	if !ok {
+		return fmt.Errorf("no system named %q is registered on the controller", name)
+	}
+
+	entry.enabled = enabled
+
+	return nil
+}
+
+// SetGroupEnabled enables or disables every system in the named group. A system in a disabled group is skipped by
+// Process, even if the system itself is enabled. Groups do not need to be created ahead of time, so a group can be
+// disabled before any systems have been added to it.
+func (c *Controller) SetGroupEnabled(group string, enabled bool) {
+	if enabled {
+		delete(c.disabledGroups, group)
+	} else {
+		c.disabledGroups[group] = true
+	}
+}
+
+// IsGroupEnabled returns false if the named group has been disabled with SetGroupEnabled, true otherwise
+func (c *Controller) IsGroupEnabled(group string) bool {
+	return !c.disabledGroups[group]
+}
+
+// IsSystemEnabled returns true if the named system is registered, is enabled, and is not in any disabled groups. That
+// is, it returns true if the system will be run the next time Process is called.
+func (c *Controller) IsSystemEnabled(name string) bool {
+	entry, ok := c.systems[name]
+	return ok && c.isEnabled(entry)
+}
+
+// isEnabled checks if a system should be run by Process
+func (c *Controller) isEnabled(entry *systemEntry) bool {
+	if !entry.enabled {
+		return false
+	}
+
+	for _, group := range entry.groups {
+		if c.disabledGroups[group] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// systemName returns the name a system registered via AddSystem is stored under
+func systemName(system System) string {
+	return reflect.TypeOf(system).String()
+}
+
+// sortSystems rebuilds the priority ordered system lists. Within each priority group, systems are topologically sorted
+// based on their dependencies. When several systems are free to run next, the one registered first is picked, so the
+// resulting order is deterministic.
+func (c *Controller) sortSystems() error {
+	groups := make(map[int][]*systemEntry)
+	priorityKeys := []int{}
+
+	for _, entry := range c.systems {
+		if _, ok := groups[entry.priority]; !ok {
+			priorityKeys = append(priorityKeys, entry.priority)
+		}
+		groups[entry.priority] = append(groups[entry.priority], entry)
+	}
+	sort.Ints(priorityKeys)
+
+	// Build the dependency graph. An edge from A to B means A must run before B.
+	edges := make(map[string][]string)
+	inDegree := make(map[string]int)
+
+	addEdge := func(from, to *systemEntry) error {
+		if from.priority > to.priority {
+			return fmt.Errorf("system %q must run before %q, but has a higher priority (%d > %d)", from.name, to.name, from.priority, to.priority)
+		}
+
+		if from.priority == to.priority {
+			edges[from.name] = append(edges[from.name], to.name)
+			inDegree[to.name]++
+		}
+
+		return nil
+	}
+
+	for _, entry := range c.systems {
+		for _, name := range entry.before {
+			if other, ok := c.systems[name]; ok {
+				if err := addEdge(entry, other); err != nil {
+					return err
+				}
+			}
+		}
+
+		for _, name := range entry.after {
+			if other, ok := c.systems[name]; ok {
+				if err := addEdge(other, entry); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	sortedSystems := make(map[int][]*systemEntry)
+
+	for _, priority := range priorityKeys {
+		group := groups[priority]
+		sort.Slice(group, func(i, j int) bool {
+			return group[i].order < group[j].order
+		})
+
+		// Kahn's algorithm. The group is sorted by registration order, so always picking the first ready system gives
+		// a stable tie break.
+		sorted := []*systemEntry{}
+		done := make(map[string]bool)
+
+		for len(sorted) < len(group) {
+			var next *systemEntry
+			for _, entry := range group {
+				if !done[entry.name] && inDegree[entry.name] == 0 {
+					next = entry
+					break
+				}
+			}
+
+			if next == nil {
+				remaining := []string{}
+				for _, entry := range group {
+					if !done[entry.name] {
+						remaining = append(remaining, entry.name)
+					}
+				}
+				return fmt.Errorf("system dependencies form a cycle between: %s", strings.Join(remaining, ", "))
+			}
+
+			done[next.name] = true
+			sorted = append(sorted, next)
+			for _, name := range edges[next.name] {
+				inDegree[name]--
+			}
+		}
+
+		sortedSystems[priority] = sorted
+	}
+
+	c.sortedSystems = sortedSystems
+	c.priorityKeys = priorityKeys
+
+	return nil
+}
@@ -1,5 +1,10 @@
 package ecs
 
+import (
+	"sort"
+	"sync"
+)
+
 // SystemMessageType represents a type of SystemMessage
 type SystemMessageType struct {
 	Name string
@@ -8,67 +13,268 @@ type SystemMessageType struct {
 // SystemMessage is a message sent between ECS systems. It contains a type, an origin, and content. A system can send
 // a message of a type, and any subscribers to that type will be notified that the message was sent, and can then act
 // accordingly.
+// Messages with a higher Priority are returned ahead of lower priority ones; messages with the same priority are
+// returned in the order they were sent. A message with a TTL is discarded once that many ticks have passed since it was
+// sent, whether or not it has been read. A TTL of 0 means the message never expires.
 type SystemMessage struct {
 	MessageType    SystemMessageType
 	Originator     System
 	MessageContent map[string]string
+	Priority       int
+	TTL            int
+
+	id       int
+	sentTick int
+	queue    *SystemMessageQueue
 }
 
 // SystemMessageQueue is a super simple way of messaging between systems. Essentially, it is nothing more than a list of
 // messages. Each message has a type, and an originator. Each system can "subscribe" to a type of message, which
 // basically just means that it will check the queue for any messages of that type before it does anything else.
 // Messages can contain a map of information, which each system that creates messages of that type, and those that
-// subscribe to it should know how to handle any information contained in the message. Ideally, the message queue will
-// be cleared out occasionally, either by the subscribing systems, or the game loop. Pretty simple for now, but should
-// solve a subset of problems nicely.
+// subscribe to it should know how to handle any information contained in the message.
+// Each subscribed system gets its own copy of a message. Once a system has read its messages (via
+// GetSubscribedMessages), they are cleared at the end of the tick. Messages that are never read are cleared once their
+// TTL runs out. When the queue is created on a Controllers EventBus, ticks follow Controller.Process; otherwise, the
+// game loop should call Tick once per turn.
+// The queue is built on top of an EventBus: every broadcast message is published on the bus as a SystemMessage event,
+// so handlers subscribed to SystemMessage on the bus see them as well. Each queue only keeps the messages sent through
+// it, so several queues can share a bus. New code should prefer publishing typed events on an EventBus directly.
 type SystemMessageQueue struct {
 	Messages      map[System][]SystemMessage
 	Subscriptions map[System][]SystemMessageType
+	bus           *EventBus
+	busHandlers   []Subscription
+	tick          int
+	nextID        int
+	read          map[System]map[int]bool
+	mutex         sync.Mutex
 }
 
-// NewSystemMessageQueue creates and initializes a new SystemMessageQueue, used for passing messages between ECS Systems
+// NewSystemMessageQueue creates and initializes a new SystemMessageQueue, used for passing messages between ECS Systems.
+// The queue uses its own EventBus, so Tick needs to be called by the game loop.
 func NewSystemMessageQueue() *SystemMessageQueue {
+	return NewSystemMessageQueueOnBus(NewEventBus())
+}
+
+// NewSystemMessageQueueOnBus creates and initializes a new SystemMessageQueue that publishes its messages on the given
+// EventBus, for example, the bus belonging to the Controller (see Controller.Events). The queue ticks whenever a
+// TickEvent is published on the bus, which the Controller does at the end of every Process call. Call Close once the
+// queue is no longer needed, so the bus stops delivering to it.
+func NewSystemMessageQueueOnBus(bus *EventBus) *SystemMessageQueue {
 	smq := SystemMessageQueue{}
 	smq.Messages = make(map[System][]SystemMessage)
 	smq.Subscriptions = make(map[System][]SystemMessageType)
+	smq.read = make(map[System]map[int]bool)
+	smq.bus = bus
+
+	smq.busHandlers = []Subscription{
+		Subscribe(bus, smq.deliver),
+		Subscribe(bus, func(event TickEvent) {
+			smq.Tick()
+		}),
+	}
+
 	return &smq
 }
 
+// Close unsubscribes the queue from its EventBus. It no longer receives messages, or ticks along with the bus, and can
+// be garbage collected once nothing else refers to it.
+func (smq *SystemMessageQueue) Close() {
+	smq.mutex.Lock()
+	handlers := smq.busHandlers
+	smq.busHandlers = nil
+	smq.mutex.Unlock()
+
+	for _, handler := range handlers {
+		smq.bus.Unsubscribe(handler)
+	}
+}
+
+// Subscribe subscribes a system to one or more message types. Only messages sent after subscribing are received.
+func (smq *SystemMessageQueue) Subscribe(system System, messageTypes ...SystemMessageType) {
+	smq.mutex.Lock()
+	defer smq.mutex.Unlock()
+
+	smq.init()
+
+	for _, messageType := range messageTypes {
+		if !MessageTypeInSlice(messageType, smq.Subscriptions[system]) {
+			smq.Subscriptions[system] = append(smq.Subscriptions[system], messageType)
+		}
+	}
+}
+
+// Unsubscribe removes a systems subscription to the given message types. Any pending messages of those types are
+// discarded. If no message types are provided, the system is unsubscribed from everything, and all of its pending
+// messages are discarded.
+func (smq *SystemMessageQueue) Unsubscribe(system System, messageTypes ...SystemMessageType) {
+	smq.mutex.Lock()
+	defer smq.mutex.Unlock()
+
+	smq.init()
+
+	if len(messageTypes) == 0 {
+		delete(smq.Subscriptions, system)
+		delete(smq.Messages, system)
+		delete(smq.read, system)
+		return
+	}
+
+	subscriptions := []SystemMessageType{}
+	for _, messageType := range smq.Subscriptions[system] {
+		if !MessageTypeInSlice(messageType, messageTypes) {
+			subscriptions = append(subscriptions, messageType)
+		}
+	}
+	smq.Subscriptions[system] = subscriptions
+
+	smq.filterMessages(system, func(message SystemMessage) bool {
+		return !MessageTypeInSlice(message.MessageType, messageTypes)
+	})
+}
+
 // BroadcastMessage appends a system message onto the games SystemMessageQueue, allowing it to consumed by a service
-// subscribes to the MessageType.
+// subscribes to the MessageType. The message has the default priority (0), and never expires.
 func (smq *SystemMessageQueue) BroadcastMessage(messageType SystemMessageType, messageContent map[string]string, originator System) {
-	newMessage := SystemMessage{MessageType: messageType, MessageContent: messageContent, Originator: originator}
+	smq.SendMessage(SystemMessage{MessageType: messageType, MessageContent: messageContent, Originator: originator})
+}
+
+// SendMessage sends a fully specified message, including its Priority and TTL, to every system subscribed to its type
+func (smq *SystemMessageQueue) SendMessage(message SystemMessage) {
+	message.queue = smq
+
+	// A queue created without a bus (ie, not through NewSystemMessageQueue) delivers messages directly
+	if smq.bus == nil {
+		smq.deliver(message)
+		return
+	}
+
+	Publish(smq.bus, message)
+}
+
+// deliver adds a message to the message queue of every system subscribed to its type. Each queue is kept ordered by
+// priority, highest first, and then by the order messages were sent in. Messages sent through another queue on the same
+// bus are ignored.
+func (smq *SystemMessageQueue) deliver(message SystemMessage) {
+	if message.queue != smq {
+		return
+	}
+
+	smq.mutex.Lock()
+	defer smq.mutex.Unlock()
+
+	smq.init()
+
+	smq.nextID++
+	message.id = smq.nextID
+	message.sentTick = smq.tick
 
 	// Find all subscriptions to this message type, and add this message to the subscribers message queue
 	for subscribedSystem, typeList := range smq.Subscriptions {
-		if MessageTypeInSlice(messageType, typeList) {
-			smq.Messages[subscribedSystem] = append(smq.Messages[subscribedSystem], newMessage)
+		if MessageTypeInSlice(message.MessageType, typeList) {
+			queue := smq.Messages[subscribedSystem]
+
+			// Find the first message with a lower priority, and insert the new message ahead of it. Messages of the
+			// same priority stay ahead of the new one, which keeps the queue first in, first out.
+			index := sort.Search(len(queue), func(i int) bool {
+				return queue[i].Priority < message.Priority
+			})
+
+			queue = append(queue, SystemMessage{})
+			copy(queue[index+1:], queue[index:])
+			queue[index] = message
+
+			smq.Messages[subscribedSystem] = queue
 		}
 	}
 }
 
-// GetSubscribedMessages returns a list of SystemMessages that have messageType. Can return an empty list
+// GetSubscribedMessages returns the list of SystemMessages waiting for a system, highest priority first. Can return an
+// empty list. The returned messages are considered read by the system, and will be cleared at the end of the tick.
 func (smq *SystemMessageQueue) GetSubscribedMessages(system System) []SystemMessage {
+	smq.mutex.Lock()
+	defer smq.mutex.Unlock()
+
+	smq.init()
+
 	messages := []SystemMessage{}
 
 	for _, message := range smq.Messages[system] {
 		messages = append(messages, message)
+
+		if smq.read[system] == nil {
+			smq.read[system] = make(map[int]bool)
+		}
+		smq.read[system][message.id] = true
 	}
 
 	return messages
 }
 
-// DeleteMessages deletes a processed message from the queue (for example, if the event has been processed)
+// DeleteMessages deletes a processed message from the queue (for example, if the event has been processed). The order
+// of the remaining messages is preserved.
 func (smq *SystemMessageQueue) DeleteMessages(messageName string, system System) {
-	modifiedQueue := smq.Messages[system]
-	for index, message := range smq.Messages[system] {
-		if message.MessageType.Name == messageName {
-			modifiedQueue[index] = modifiedQueue[len(modifiedQueue)-1]
-			modifiedQueue = modifiedQueue[:len(modifiedQueue)-1]
+	smq.mutex.Lock()
+	defer smq.mutex.Unlock()
+
+	smq.init()
+
+	smq.filterMessages(system, func(message SystemMessage) bool {
+		return message.MessageType.Name != messageName
+	})
+}
+
+// Tick advances the queue by one tick. Messages that every subscriber has read, and messages whose TTL has run out,
+// are cleared.
+func (smq *SystemMessageQueue) Tick() {
+	smq.mutex.Lock()
+	defer smq.mutex.Unlock()
+
+	smq.init()
+
+	smq.tick++
+
+	for system := range smq.Messages {
+		read := smq.read[system]
+		smq.filterMessages(system, func(message SystemMessage) bool {
+			expired := message.TTL > 0 && smq.tick-message.sentTick >= message.TTL
+			return !read[message.id] && !expired
+		})
+	}
+
+	smq.read = make(map[System]map[int]bool)
+}
+
+// filterMessages keeps only the messages in a systems queue for which keep returns true, preserving their order
+func (smq *SystemMessageQueue) filterMessages(system System, keep func(message SystemMessage) bool) {
+	if _, ok := smq.Messages[system]; !ok {
+		return
+	}
+
+	kept := []SystemMessage{}
+	for _, message := range smq.Messages[system] {
+		if keep(message) {
+			kept = append(kept, message)
 		}
 	}
 
-	smq.Messages[system] = modifiedQueue
+	smq.Messages[system] = kept
+}
+
+// init makes sure the queues maps are initialized, for queues that were not created through a constructor
+func (smq *SystemMessageQueue) init() {
+	if smq.Messages == nil {
+		smq.Messages = make(map[System][]SystemMessage)
+	}
+
+	if smq.Subscriptions == nil {
+		smq.Subscriptions = make(map[System][]SystemMessageType)
+	}
+
+	if smq.read == nil {
+		smq.read = make(map[System]map[int]bool)
+	}
 }
 
 //MessageTypeInSlice will return true if the MessageType provided is present in the slice provided, false otherwise
@@ -1,6 +1,7 @@
 package gamemap
 
 import (
+	"github.com/gogue-framework/gogue/randomnumbergenerator"
 	"github.com/gogue-framework/gogue/ui"
 	"github.com/stretchr/testify/assert"
 	"testing"
@@ -43,6 +44,15 @@ func TestMap_InitializeMap(t *testing.T) {
 
 	assert.Equal(t, len(gameMap.Tiles), 101)
 	assert.Equal(t, len(gameMap.Tiles[0]), 101)
+	assert.NotNil(t, gameMap.RNG)
+
+	// An RNG that has already been set is kept, so the map can be generated from its seed
+	rng := randomnumbergenerator.NewSeededRNG(42)
+	gameMap = GameMap{Width: 10, Height: 10, RNG: rng}
+	gameMap.InitializeMap()
+
+	assert.Equal(t, rng, gameMap.RNG)
+	assert.Equal(t, int64(42), gameMap.RNG.GetSeed())
 }
 
 func TestMap_IsBlocked(t *testing.T) {
@@ -65,6 +75,28 @@ func TestMap_IsBlocked(t *testing.T) {
 	assert.False(t, gameMap.IsBlocked(1, 1))
 }
 
+// blockerSet is a Blocker that blocks a fixed set of locations
+type blockerSet map[CoordinatePair]bool
+
+func (bs blockerSet) IsBlocking(x, y int) bool {
+	return bs[CoordinatePair{x, y}]
+}
+
+func TestMap_IsBlockedWithBlockers(t *testing.T) {
+	wallGlyph := ui.NewGlyph("#", "white", "gray")
+	floorGlyph := ui.NewGlyph(".", "white", "gray")
+
+	gameMap := GameMap{Width: 10, Height: 10}
+	gameMap.InitializeMap()
+	generateArena(&gameMap, wallGlyph, floorGlyph)
+
+	gameMap.Blockers = blockerSet{CoordinatePair{2, 2}: true}
+
+	assert.True(t, gameMap.IsBlocked(0, 0))
+	assert.True(t, gameMap.IsBlocked(2, 2))
+	assert.False(t, gameMap.IsBlocked(1, 1))
+}
+
 func TestMap_GetNeighbors(t *testing.T) {
 	wallGlyph := ui.NewGlyph("#", "white", "gray")
 	floorGlyph := ui.NewGlyph(".", "white", "gray")
@@ -2,9 +2,8 @@ package gamemap
 
 import (
 	"github.com/gogue-framework/gogue/camera"
+	"github.com/gogue-framework/gogue/randomnumbergenerator"
 	"github.com/gogue-framework/gogue/ui"
-	"math/rand"
-	"time"
 )
 
 // CoordinatePair represents a point in a 2D space
@@ -39,19 +38,32 @@ func (t *Tile) IsWall() bool {
 	return false
 }
 
+// Blocker reports whether something other than the map itself, such as an entity, blocks movement at a location. An
+// ecs.SpatialIndex with a blocking component set satisfies this interface.
+type Blocker interface {
+	IsBlocking(x, y int) bool
+}
+
 // GameMap is a 2D slice of Tile. The bounds of the map are determined by the width and height. FloorTiles keeps track
 // of all tiles in the GameMap that are marked as floors (does not block movement or sight, and can be occupied), this
 // useful for finding open tiles for spawning entities.
+// Blockers is optional. If it is set, IsBlocked also considers a location blocked if the Blocker says so, which allows
+// entities (monsters, closed doors, etc) to block movement.
+// RNG is the random number generator used to generate the map. Setting it to an RNG with a known seed before generating
+// the map means the same map is generated every time, which allows levels to be regenerated from their seed:
+// gameMap := &gamemap.GameMap{Width: 80, Height: 50, RNG: randomnumbergenerator.NewSeededRNG(seed)}
 type GameMap struct {
 	Width      int
 	Height     int
 	Tiles      [][]*Tile
 	FloorTiles []*Tile
+	Blockers   Blocker
+	RNG        *randomnumbergenerator.RNG
 }
 
 // InitializeMap sets up a GameMap for use. It sets the Tiles property of the GameMap to a 2D array of Tile objects,
-// with a width and height matching those set for the GameMap. It also initializes a random seed to use for map
-// generation
+// with a width and height matching those set for the GameMap. If no RNG has been set for map generation, an RNG seeded
+// with the current time is created.
 func (m *GameMap) InitializeMap() {
 	// Initialize a two dimensional array that will represent the current game map (of dimensions Width x Height)
 	m.Tiles = make([][]*Tile, m.Width+1)
@@ -59,8 +71,11 @@ func (m *GameMap) InitializeMap() {
 		m.Tiles[i] = make([]*Tile, m.Height+1)
 	}
 
-	// Set a seed for procedural generation
-	rand.Seed(time.Now().UTC().UnixNano())
+	// Set up a random number generator for procedural generation, unless one has been provided, in which case it is
+	// left alone, so the map can be reproduced from its seed
+	if m.RNG == nil {
+		m.RNG = randomnumbergenerator.NewRNG()
+	}
 }
 
 // Render draws a GameMap to the terminal, within a Camera viewport. It will only draw tiles from the GameMap that
@@ -99,13 +114,19 @@ func (m *GameMap) Render(gameCamera *camera.GameCamera, newCameraX, newCameraY i
 	}
 }
 
-// IsBlocked returns true if the Tile in the GameMap has its blocked property set to true. False otherwise.
+// IsBlocked returns true if the Tile in the GameMap has its blocked property set to true, or if the GameMaps Blockers
+// report the location as blocked. False otherwise.
 func (m *GameMap) IsBlocked(x, y int) bool {
 	// Check to see if the provided coordinates contain a blocked tile
 	if m.Tiles[x][y].Blocked {
 		return true
 	}
 
+	// Check to see if anything on the tile, such as an entity, blocks it
+	if m.Blockers != nil && m.Blockers.IsBlocking(x, y) {
+		return true
+	}
+
 	return false
 }
 
@@ -2,8 +2,8 @@ package maptypes
 
 import (
 	"github.com/gogue-framework/gogue/gamemap"
+	"github.com/gogue-framework/gogue/randomnumbergenerator"
 	"github.com/gogue-framework/gogue/ui"
-	"math/rand"
 	"sort"
 )
 
@@ -21,6 +21,27 @@ func (s bySize) Less(i, j int) bool {
 	return len(s[i]) < len(s[j])
 }
 
+// CavernOptions controls the layout GenerateCavernWithOptions produces. SmoothingPasses is the number of smoothing
+// passes made over the initial random layout, as in GenerateCavern.
+// If ConnectCaverns is true, caverns that are not connected to the largest cavern are joined to it by tunnels, instead
+// of being filled in, so the whole map is used. Each cavern is tunnelled to from the closest point of the caverns already
+// connected, and ConnectionSmoothingPasses more smoothing passes are made afterwards, to make the tunnels look more
+// natural. Smoothing can close tunnels up again, so caverns are reconnected after smoothing, until everything is joined.
+// MinFloorPercentage is the smallest percentage of the map (inside its outer walls) that must be floor. If the finished
+// map has less floor than this, it is generated again, up to MaxAttempts times in total.
+type CavernOptions struct {
+	SmoothingPasses           int
+	ConnectCaverns            bool
+	ConnectionSmoothingPasses int
+	MinFloorPercentage        int
+	MaxAttempts               int
+}
+
+// DefaultCavernOptions returns CavernOptions that connect every cavern, and require at least 40% of the map to be floor
+func DefaultCavernOptions() CavernOptions {
+	return CavernOptions{SmoothingPasses: 5, ConnectCaverns: true, ConnectionSmoothingPasses: 2, MinFloorPercentage: 40, MaxAttempts: 10}
+}
+
 // GenerateCavern uses a cellular automata algorithm to create a fairly natural looking, 2D, cavern layout. It accepts
 // Glyphs representing the walls and floor.
 // The algorithm works in 6 steps. Step 1 fills the entire map with random wall and floor tiles, in roughly a 40/60 mix
@@ -28,14 +49,44 @@ func (s bySize) Less(i, j int) bool {
 // number of times to smooth out the generated caverns. Step 4 seals up the edges of the map, so there are no paths off
 // the edge of the map. Step 5 uses a flood fill algorithm to find the largest cavern, and finally, step 6, fills all
 // smaller caverns. This algorithm is simple and does not connect unconnected caverns, and simply uses the largest
-// cavern as the play area.
+// cavern as the play area. Use GenerateCavernWithOptions to connect the caverns instead.
+// All randomness comes from the maps RNG, so a map with the same seed always has the same layout.
 func GenerateCavern(surface *gamemap.GameMap, wallGlyph, floorGlyph ui.Glyph, smoothingPasses int) {
+	GenerateCavernWithOptions(surface, wallGlyph, floorGlyph, CavernOptions{SmoothingPasses: smoothingPasses})
+}
+
+// GenerateCavernWithOptions works the same way as GenerateCavern, but can tunnel between caverns rather than filling in
+// all but the largest, and can retry generation until enough of the map is floor (see CavernOptions). It returns false
+// if the map still has less floor than MinFloorPercentage after MaxAttempts attempts, in which case the last attempt is
+// kept.
+func GenerateCavernWithOptions(surface *gamemap.GameMap, wallGlyph, floorGlyph ui.Glyph, options CavernOptions) bool {
+	if options.MaxAttempts < 1 {
+		options.MaxAttempts = 1
+	}
+
+	area := (surface.Width - 2) * (surface.Height - 2)
+
+	for attempt := 0; attempt < options.MaxAttempts; attempt++ {
+		generateCavernAttempt(surface, wallGlyph, floorGlyph, options)
+
+		if area <= 0 || len(surface.FloorTiles)*100 >= options.MinFloorPercentage*area {
+			return true
+		}
+	}
+
+	return false
+}
+
+// generateCavernAttempt generates a single cavern layout
+func generateCavernAttempt(surface *gamemap.GameMap, wallGlyph, floorGlyph ui.Glyph, options CavernOptions) {
+	rng := surfaceRNG(surface)
+
 	// Step 1: Fill the map space with a random assortment of walls and floors. This uses a roughly 40/60 ratio in favor
 	// of floors, as I've found that to produce the nicest results.
 
 	for x := 0; x < surface.Width; x++ {
 		for y := 0; y < surface.Height; y++ {
-			state := rand.Intn(100)
+			state := rng.Percentage()
 			// All Tiles are created visible, by default. It is left up to the developer to set Tiles to not visible
 			// as they see fit (say, through use of the FoV tools in Gogue).
 			if state < 30 {
@@ -58,131 +109,217 @@ func GenerateCavern(surface *gamemap.GameMap, wallGlyph, floorGlyph ui.Glyph, sm
 				wallTwoAway := countWallsNStepsAway(surface, 1, x, y)
 
 				if wallOneAway >= 5 || wallTwoAway <= 2 {
-					surface.Tiles[x][y].Blocked = true
-					surface.Tiles[x][y].BlocksSight = true
-					surface.Tiles[x][y].Glyph = wallGlyph
+					setWall(surface, x, y, wallGlyph)
 				} else {
-					surface.Tiles[x][y].Blocked = false
-					surface.Tiles[x][y].BlocksSight = false
-					surface.Tiles[x][y].Glyph = floorGlyph
+					setFloor(surface, x, y, floorGlyph)
 				}
 			}
 		}
 	}
 
 	// Step 3: Make a few more passes, smoothing further, and removing any small or single tile, unattached walls.
-	for i := 0; i < smoothingPasses; i++ {
+	smoothCavern(surface, wallGlyph, floorGlyph, options.SmoothingPasses)
+
+	// Step 4: Seal up the edges of the map, so the player, and the following flood fill passes, cannot go beyond the
+	// intended game area
+	sealEdges(surface, wallGlyph)
+
+	// Step 5: Flood fill. This will find each individual cavern in the cave system, and add them to a list. It will
+	// then find the largest one, and will make that as the main play area.
+	caverns := findCaverns(surface)
+	if len(caverns) == 0 {
+		surface.FloorTiles = nil
+		return
+	}
+
+	// Step 6: Either tunnel between the caverns, smoothing the tunnels out afterwards, or fill in the smaller caverns.
+	// Smoothing can cut caverns off again, so tunnelling is repeated a few times. If there is still more than one
+	// cavern after that, they are joined without any more smoothing, so the map is always connected.
+	if options.ConnectCaverns {
+		for pass := 0; pass < 3 && len(caverns) > 1 && options.ConnectionSmoothingPasses > 0; pass++ {
+			connectCaverns(surface, caverns, floorGlyph, rng)
+			smoothCavern(surface, wallGlyph, floorGlyph, options.ConnectionSmoothingPasses)
+			sealEdges(surface, wallGlyph)
+			caverns = findCaverns(surface)
+		}
+
+		if len(caverns) > 1 {
+			connectCaverns(surface, caverns, floorGlyph, rng)
+			caverns = findCaverns(surface)
+		}
+	}
+
+	// Smoothing the tunnels can, very rarely, leave no floor at all
+	if len(caverns) == 0 {
+		surface.FloorTiles = nil
+		return
+	}
+
+	// Sort the caverns slice by size. This will make the largest cavern last, which will then be removed from the list.
+	// Then, fill in any remaining caverns (aside from the main one). This will ensure that there are no areas on the
+	// map that the player cannot reach.
+	sort.Stable(bySize(caverns))
+
+	// Take the largest cavern (The one being used as the map), and record it as a list of open floor tiles, since thats
+	// what it represents. This will be used for content generation.
+	surface.FloorTiles = caverns[len(caverns)-1]
+	caverns = caverns[:len(caverns)-1]
+
+	for i := 0; i < len(caverns); i++ {
+		for j := 0; j < len(caverns[i]); j++ {
+			setWall(surface, caverns[i][j].X, caverns[i][j].Y, wallGlyph)
+		}
+	}
+}
+
+// smoothCavern makes a number of smoothing passes over the map, turning any tile with 5 or more walls within 1 space of
+// it into a wall, and any other tile into a floor
+func smoothCavern(surface *gamemap.GameMap, wallGlyph, floorGlyph ui.Glyph, passes int) {
+	for i := 0; i < passes; i++ {
 		for x := 0; x < surface.Width; x++ {
 			for y := 0; y < surface.Height-1; y++ {
 				wallOneAway := countWallsNStepsAway(surface, 1, x, y)
 
 				if wallOneAway >= 5 {
-					surface.Tiles[x][y].Blocked = true
-					surface.Tiles[x][y].BlocksSight = true
-					surface.Tiles[x][y].Glyph = wallGlyph
+					setWall(surface, x, y, wallGlyph)
 				} else {
-					surface.Tiles[x][y].Blocked = false
-					surface.Tiles[x][y].BlocksSight = false
-					surface.Tiles[x][y].Glyph = floorGlyph
+					setFloor(surface, x, y, floorGlyph)
 				}
 			}
 		}
 	}
+}
 
-	// Step 4: Seal up the edges of the map, so the player, and the following flood fill passes, cannot go beyond the
-	// intended game area
+// sealEdges turns every tile around the edge of the map into a wall
+func sealEdges(surface *gamemap.GameMap, wallGlyph ui.Glyph) {
 	for x := 0; x < surface.Width; x++ {
 		for y := 0; y < surface.Height; y++ {
 			if x == 0 || x == surface.Width-1 || y == 0 || y == surface.Height-1 {
-				surface.Tiles[x][y].Blocked = true
-				surface.Tiles[x][y].BlocksSight = true
-				surface.Tiles[x][y].Glyph = wallGlyph
+				setWall(surface, x, y, wallGlyph)
 			}
 		}
 	}
+}
 
-	// Step 5: Flood fill. This will find each individual cavern in the cave system, and add them to a list. It will
-	// then find the largest one, and will make that as the main play area. The smaller caverns will be filled in.
-	// In the future, it might make sense to tunnel between caverns, and apply a few more smoothing passes, to make
-	// larger, more realistic caverns.
-
-	var cavern []*gamemap.Tile
-	var totalCavernArea []*gamemap.Tile
+// findCaverns uses a flood fill to find every cavern on the map: each group of floor tiles that can be reached from one
+// another by moving up, down, left, or right. Caverns are returned in the order they are found, scanning the map column
+// by column, and the tiles of each cavern in the order they were reached.
+func findCaverns(surface *gamemap.GameMap) [][]*gamemap.Tile {
 	var caverns [][]*gamemap.Tile
-	var tile *gamemap.Tile
-	var node *gamemap.Tile
+	visited := make(map[*gamemap.Tile]bool)
 
-	for x := 0; x < surface.Width-1; x++ {
-		for y := 0; y < surface.Height-1; y++ {
-			tile = surface.Tiles[x][y]
+	for x := 0; x < surface.Width; x++ {
+		for y := 0; y < surface.Height; y++ {
+			tile := surface.Tiles[x][y]
 
 			// If the current tile is a wall, or has already been visited, ignore it and move on
-			if !tile.Visited && !tile.IsWall() {
-				// This is a non-wall, unvisited tile
-				cavern = append(cavern, surface.Tiles[x][y])
-
-				for len(cavern) > 0 {
-					// While the current node tile has valid neighbors, keep looking for more valid neighbors off of
-					// each one
-					node = cavern[len(cavern)-1]
-					cavern = cavern[:len(cavern)-1]
-
-					if !node.Visited && !node.IsWall() {
-						// Mark the node as visited, and add it to the cavern area for this cavern
-						node.Visited = true
-						totalCavernArea = append(totalCavernArea, node)
-
-						// Add the tile to the west, if valid
-						if node.X-1 > 0 && !surface.Tiles[node.X-1][node.Y].IsWall() {
-							cavern = append(cavern, surface.Tiles[node.X-1][node.Y])
-						}
-
-						// Add the tile to east, if valid
-						if node.X+1 < surface.Width && !surface.Tiles[node.X+1][node.Y].IsWall() {
-							cavern = append(cavern, surface.Tiles[node.X+1][node.Y])
-						}
-
-						// Add the tile to north, if valid
-						if node.Y-1 > 0 && !surface.Tiles[node.X][node.Y-1].IsWall() {
-							cavern = append(cavern, surface.Tiles[node.X][node.Y-1])
-						}
-
-						// Add the tile to south, if valid
-						if node.Y+1 < surface.Height && !surface.Tiles[node.X][node.Y+1].IsWall() {
-							cavern = append(cavern, surface.Tiles[node.X][node.Y+1])
-						}
+			if visited[tile] || tile.IsWall() {
+				continue
+			}
+
+			// This is a non-wall, unvisited tile. While the current node tile has valid neighbors, keep looking for
+			// more valid neighbors off of each one
+			var cavern []*gamemap.Tile
+			stack := []*gamemap.Tile{tile}
+			visited[tile] = true
+
+			for len(stack) > 0 {
+				node := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				cavern = append(cavern, node)
+
+				for _, offset := range [][2]int{{-1, 0}, {1, 0}, {0, -1}, {0, 1}} {
+					neighborX, neighborY := node.X+offset[0], node.Y+offset[1]
+					if neighborX < 0 || neighborY < 0 || neighborX >= surface.Width || neighborY >= surface.Height {
+						continue
 					}
-				}
 
-				// All non-wall tiles have been found for the current cavern, add it to the list, and start looking for
-				// the next one
-				caverns = append(caverns, totalCavernArea)
-				totalCavernArea = nil
-			} else {
-				tile.Visited = true
+					neighbor := surface.Tiles[neighborX][neighborY]
+					if !visited[neighbor] && !neighbor.IsWall() {
+						visited[neighbor] = true
+						stack = append(stack, neighbor)
+					}
+				}
 			}
+
+			// All non-wall tiles have been found for the current cavern, add it to the list, and start looking for
+			// the next one
+			caverns = append(caverns, cavern)
 		}
 	}
 
-	// Sort the caverns slice by size. This will make the largest cavern last, which will then be removed from the list.
-	// Then, fill in any remaining caverns (aside from the main one). This will ensure that there are no areas on the
-	// map that the player cannot reach.
-	sort.Sort(bySize(caverns))
+	return caverns
+}
 
-	// Take the largest cavern (The one being used as the map), and record it as a list of open floor tiles, since thats
-	// what it represents. This will be used for content generation.
-	surface.FloorTiles = caverns[len(caverns)-1]
-	caverns = caverns[:len(caverns)-1]
+// connectCaverns tunnels between caverns until they are all connected. Starting from the largest cavern, the closest
+// unconnected cavern is found, and a tunnel dug between the closest pair of tiles on the edges of the two, until every
+// cavern has been reached.
+func connectCaverns(surface *gamemap.GameMap, caverns [][]*gamemap.Tile, floorGlyph ui.Glyph, rng *randomnumbergenerator.RNG) {
+	sorted := append([][]*gamemap.Tile{}, caverns...)
+	sort.Stable(sort.Reverse(bySize(sorted)))
 
-	for i := 0; i < len(caverns); i++ {
-		for j := 0; j < len(caverns[i]); j++ {
-			caverns[i][j].Blocked = true
-			caverns[i][j].BlocksSight = true
-			caverns[i][j].Glyph = wallGlyph
+	connected := cavernEdges(surface, sorted[0])
+	unconnected := [][]*gamemap.Tile{}
+	for _, cavern := range sorted[1:] {
+		unconnected = append(unconnected, cavernEdges(surface, cavern))
+	}
+
+	for len(unconnected) > 0 {
+		var from, to *gamemap.Tile
+		closest, closestCavern := -1, -1
+
+		for i, cavern := range unconnected {
+			for _, cavernTile := range cavern {
+				for _, connectedTile := range connected {
+					distance := abs(cavernTile.X-connectedTile.X) + abs(cavernTile.Y-connectedTile.Y)
+					if closest < 0 || distance < closest {
+						closest, closestCavern = distance, i
+						from, to = connectedTile, cavernTile
+					}
+				}
+			}
 		}
+
+		// Tunnels are two tiles wide, so that they have a chance of surviving any smoothing afterwards
+		for _, point := range corridorPath(from.X, from.Y, to.X, to.Y, LShapedCorridors, rng) {
+			setFloor(surface, point.X, point.Y, floorGlyph)
+			if point.X+1 < surface.Width-1 {
+				setFloor(surface, point.X+1, point.Y, floorGlyph)
+			}
+			if point.Y+1 < surface.Height-1 {
+				setFloor(surface, point.X, point.Y+1, floorGlyph)
+			}
+		}
+
+		connected = append(connected, unconnected[closestCavern]...)
+		unconnected = append(unconnected[:closestCavern], unconnected[closestCavern+1:]...)
 	}
 }
 
+// cavernEdges returns the tiles of a cavern that are next to a wall. The closest tiles of two caverns are always on
+// their edges, so only these need to be considered when tunnelling.
+func cavernEdges(surface *gamemap.GameMap, cavern []*gamemap.Tile) []*gamemap.Tile {
+	edges := []*gamemap.Tile{}
+
+	for _, tile := range cavern {
+		for _, offset := range [][2]int{{-1, 0}, {1, 0}, {0, -1}, {0, 1}} {
+			if surface.Tiles[tile.X+offset[0]][tile.Y+offset[1]].IsWall() {
+				edges = append(edges, tile)
+				break
+			}
+		}
+	}
+
+	return edges
+}
+
+// setWall turns the tile at x, y into a wall
+func setWall(surface *gamemap.GameMap, x, y int, wallGlyph ui.Glyph) {
+	surface.Tiles[x][y].Blocked = true
+	surface.Tiles[x][y].BlocksSight = true
+	surface.Tiles[x][y].Glyph = wallGlyph
+}
+
 func countWallsNStepsAway(surface *gamemap.GameMap, n int, x int, y int) int {
 	// Return the number of wall tiles that are within n spaces of the given tile
 	wallCount := 0
@@ -0,0 +1,168 @@
+package maptypes
+
+import (
+	"github.com/gogue-framework/gogue/gamemap"
+	"github.com/gogue-framework/gogue/ui"
+)
+
+// DrunkardsWalkOptions controls the layout GenerateDrunkardsWalk produces.
+// Walkers is the number of walkers carving at the same time. More walkers produce a more open, blobby cave, while a
+// single walker produces long, winding passages. WalkerLifetime is the number of steps a walker takes before it gives
+// up, and a new walker starts from a random floor tile that has already been carved. A lifetime of zero means walkers
+// never give up.
+// TurnChance is the percentage chance that a walker picks a new direction on each step. At 100 walkers stagger about
+// completely at random, and lower values produce straighter tunnels.
+// EdgeMargin is how close (in tiles) walkers may come to the edge of the map. It is at least 1, so the edge of the map
+// is always a wall. Walkers that reach the margin turn back towards the middle of the map.
+// StartX and StartY are where every walker starts. If both are zero, walkers start in the middle of the map.
+// TargetFloorPercentage is the percentage of the map (inside its outer walls) to carve into floor.
+type DrunkardsWalkOptions struct {
+	Walkers               int
+	WalkerLifetime        int
+	TurnChance            int
+	EdgeMargin            int
+	StartX                int
+	StartY                int
+	TargetFloorPercentage int
+}
+
+// DefaultDrunkardsWalkOptions returns DrunkardsWalkOptions for a single walker, staggering at random from the middle of
+// the map, until 40% of the map is floor
+func DefaultDrunkardsWalkOptions() DrunkardsWalkOptions {
+	return DrunkardsWalkOptions{Walkers: 1, TurnChance: 100, EdgeMargin: 1, TargetFloorPercentage: 40}
+}
+
+// walker is a single drunkard, carving floor as it goes
+type walker struct {
+	x     int
+	y     int
+	dx    int
+	dy    int
+	steps int
+}
+
+// directions are the directions a walker can step in
+var directions = [][2]int{{0, -1}, {1, 0}, {0, 1}, {-1, 0}}
+
+// GenerateDrunkardsWalk creates a cave using a drunkards walk. It accepts Glyphs representing the walls and floor, and
+// options controlling how the walkers behave (see DrunkardsWalkOptions).
+// The algorithm works in 2 steps. Step 1 fills the map with walls. Step 2 sets walkers loose from the start point. Each
+// walker steps to a neighboring tile at a time, turning at random, and turns the tile it lands on into floor. Walkers
+// take turns, one step each, until enough of the map has been carved. Every walker starts on floor that has already
+// been carved, and only ever steps up, down, left, or right, so every floor tile can always be reached from every
+// other.
+// All randomness comes from the maps RNG, so a map with the same seed and options always has the same layout. Every
+// floor tile is recorded in FloorTiles. It returns false if the target percentage could not be reached, which happens
+// when it is higher than the space inside the EdgeMargin allows, or when walkers that never turn or give up run out of
+// new ground to carve.
+func GenerateDrunkardsWalk(surface *gamemap.GameMap, wallGlyph, floorGlyph ui.Glyph, options DrunkardsWalkOptions) bool {
+	rng := surfaceRNG(surface)
+
+	if options.Walkers < 1 {
+		options.Walkers = 1
+	}
+
+	if options.EdgeMargin < 1 {
+		options.EdgeMargin = 1
+	}
+
+	// Step 1: Fill the map with walls. The cave is carved out of them.
+	for x := 0; x <= surface.Width; x++ {
+		for y := 0; y <= surface.Height; y++ {
+			// All Tiles are created visible, by default. It is left up to the developer to set Tiles to not visible
+			// as they see fit (say, through use of the FoV tools in Gogue).
+			surface.Tiles[x][y] = &gamemap.Tile{Glyph: wallGlyph, Blocked: true, BlocksSight: true, Visited: false, Explored: false, Visible: true, X: x, Y: y, Noises: make(map[int]float64)}
+		}
+	}
+
+	surface.FloorTiles = nil
+
+	// Walkers stay inside this area
+	minX, minY := options.EdgeMargin, options.EdgeMargin
+	maxX, maxY := surface.Width-1-options.EdgeMargin, surface.Height-1-options.EdgeMargin
+	if maxX < minX || maxY < minY {
+		return false
+	}
+
+	startX, startY := options.StartX, options.StartY
+	if startX == 0 && startY == 0 {
+		startX, startY = surface.Width/2, surface.Height/2
+	}
+	startX = clamp(startX, minX, maxX)
+	startY = clamp(startY, minY, maxY)
+
+	target := options.TargetFloorPercentage * (surface.Width - 2) * (surface.Height - 2) / 100
+	carvable := (maxX - minX + 1) * (maxY - minY + 1)
+
+	// Step 2: Set the walkers loose, and let them carve until enough of the map is floor
+	floorTiles := []*gamemap.Tile{}
+	carve := func(x, y int) {
+		if surface.Tiles[x][y].IsWall() {
+			setFloor(surface, x, y, floorGlyph)
+			floorTiles = append(floorTiles, surface.Tiles[x][y])
+		}
+	}
+
+	carve(startX, startY)
+
+	walkers := make([]*walker, options.Walkers)
+	for i := range walkers {
+		direction := directions[rng.Range(0, len(directions))]
+		walkers[i] = &walker{x: startX, y: startY, dx: direction[0], dy: direction[1]}
+	}
+
+	// Walkers that never turn, or never give up, may not be able to reach the target, so give up eventually
+	maxSteps := carvable * 100
+
+	for steps := 0; len(floorTiles) < target && len(floorTiles) < carvable && steps < maxSteps; steps++ {
+		for _, w := range walkers {
+			if options.WalkerLifetime > 0 && w.steps >= options.WalkerLifetime {
+				// This walker has had enough. Start a new one somewhere that has already been carved.
+				start := floorTiles[rng.Range(0, len(floorTiles))]
+				w.x, w.y, w.steps = start.X, start.Y, 0
+			}
+
+			if rng.Percentage() < options.TurnChance {
+				direction := directions[rng.Range(0, len(directions))]
+				w.dx, w.dy = direction[0], direction[1]
+			}
+
+			// Turn back towards the middle of the map, rather than stepping into the margin
+			if w.x+w.dx < minX || w.x+w.dx > maxX {
+				w.dx = -w.dx
+			}
+			if w.y+w.dy < minY || w.y+w.dy > maxY {
+				w.dy = -w.dy
+			}
+
+			// A map only one tile wide (or high) leaves nowhere to go
+			if w.x+w.dx >= minX && w.x+w.dx <= maxX && w.y+w.dy >= minY && w.y+w.dy <= maxY {
+				w.x += w.dx
+				w.y += w.dy
+			}
+			w.steps++
+
+			carve(w.x, w.y)
+			if len(floorTiles) >= target {
+				break
+			}
+		}
+	}
+
+	surface.FloorTiles = collectFloorTiles(surface)
+
+	return len(floorTiles) >= target
+}
+
+// clamp returns n, limited to the range minimum to maximum
+func clamp(n, minimum, maximum int) int {
+	if n < minimum {
+		return minimum
+	}
+
+	if n > maximum {
+		return maximum
+	}
+
+	return n
+}
@@ -0,0 +1,413 @@
+package maptypes
+
+import (
+	"github.com/gogue-framework/gogue/gamemap"
+	"github.com/gogue-framework/gogue/randomnumbergenerator"
+	"github.com/gogue-framework/gogue/ui"
+)
+
+// Rect is a rectangular area of a map, such as a room. X and Y are the top left corner of the rectangle, and every
+// tile from X to X+Width-1, and Y to Y+Height-1, is inside it.
+type Rect struct {
+	X      int
+	Y      int
+	Width  int
+	Height int
+}
+
+// Center returns the tile at the center of the rectangle
+func (r Rect) Center() (int, int) {
+	return r.X + r.Width/2, r.Y + r.Height/2
+}
+
+// Contains returns true if the given tile is inside the rectangle
+func (r Rect) Contains(x, y int) bool {
+	return x >= r.X && x < r.X+r.Width && y >= r.Y && y < r.Y+r.Height
+}
+
+// Intersects returns true if the rectangle overlaps another rectangle
+func (r Rect) Intersects(other Rect) bool {
+	return r.X < other.X+other.Width && other.X < r.X+r.Width && r.Y < other.Y+other.Height && other.Y < r.Y+r.Height
+}
+
+// CorridorStyle determines the shape of the corridors GenerateDungeon uses to connect rooms
+type CorridorStyle int
+
+const (
+	// LShapedCorridors run straight along one axis, and then straight along the other
+	LShapedCorridors CorridorStyle = iota
+	// WindingCorridors wander towards their destination, turning at random
+	WindingCorridors
+)
+
+// DungeonOptions controls the layout GenerateDungeon produces. MinRoomSize and MaxRoomSize are the smallest and largest
+// width and height of a room, not counting its walls. MinLeafSize is the smallest area the map is split into, each
+// area holding a single room, so smaller values produce more, smaller, rooms. MinLeafSize is never less than
+// MinRoomSize plus two, so there is always space for a room and its walls.
+type DungeonOptions struct {
+	MinRoomSize   int
+	MaxRoomSize   int
+	MinLeafSize   int
+	CorridorStyle CorridorStyle
+}
+
+// DefaultDungeonOptions returns DungeonOptions that produce rooms of 4 to 10 tiles across, connected by L shaped
+// corridors
+func DefaultDungeonOptions() DungeonOptions {
+	return DungeonOptions{MinRoomSize: 4, MaxRoomSize: 10, MinLeafSize: 10, CorridorStyle: LShapedCorridors}
+}
+
+// Dungeon describes the layout created by GenerateDungeon. Rooms holds the area of every room, Corridors holds the path
+// of every corridor, from the room it starts in to the room it ends in, and Doors holds the tiles where a corridor
+// enters a room through a gap in its wall, which are good places to put doors.
+type Dungeon struct {
+	Rooms     []Rect
+	Corridors [][]gamemap.CoordinatePair
+	Doors     []gamemap.CoordinatePair
+}
+
+// bspLeaf is an area of the map in the binary space partition. A leaf either holds a room, or has been split into two
+// smaller leaves.
+type bspLeaf struct {
+	area  Rect
+	room  *Rect
+	left  *bspLeaf
+	right *bspLeaf
+}
+
+// GenerateDungeon creates a classic rooms and corridors layout, using binary space partitioning (BSP). It accepts Glyphs
+// representing the walls and floor, and options controlling the size of the rooms, and the style of the corridors.
+// The algorithm works in 4 steps. Step 1 fills the map with walls. Step 2 recursively splits the map into two smaller
+// areas, either side by side or one above the other, until the areas are too small to split again. Step 3 places a
+// room of random size and position in each area. Step 4 works back up through the splits, joining the two halves of
+// each split together with a corridor between their closest rooms, which guarantees every room can be reached. Every
+// floor tile is recorded in FloorTiles, and the layout is returned, so rooms can be furnished, and doors placed.
+// All randomness comes from the maps RNG, so a map with the same seed and options always has the same layout.
+func GenerateDungeon(surface *gamemap.GameMap, wallGlyph, floorGlyph ui.Glyph, options DungeonOptions) *Dungeon {
+	dungeon := Dungeon{}
+	rng := surfaceRNG(surface)
+
+	if options.MinRoomSize < 1 {
+		options.MinRoomSize = 1
+	}
+
+	if options.MaxRoomSize < options.MinRoomSize {
+		options.MaxRoomSize = options.MinRoomSize
+	}
+
+	if options.MinLeafSize < options.MinRoomSize+2 {
+		options.MinLeafSize = options.MinRoomSize + 2
+	}
+
+	// Step 1: Fill the map with walls. Rooms and corridors are carved out of them.
+	for x := 0; x <= surface.Width; x++ {
+		for y := 0; y <= surface.Height; y++ {
+			// All Tiles are created visible, by default. It is left up to the developer to set Tiles to not visible
+			// as they see fit (say, through use of the FoV tools in Gogue).
+			surface.Tiles[x][y] = &gamemap.Tile{Glyph: wallGlyph, Blocked: true, BlocksSight: true, Visited: false, Explored: false, Visible: true, X: x, Y: y, Noises: make(map[int]float64)}
+		}
+	}
+
+	// Step 2: Split the map up. The outer edge of the map is left out, so that it is always a wall.
+	root := &bspLeaf{area: Rect{X: 1, Y: 1, Width: surface.Width - 2, Height: surface.Height - 2}}
+	if root.area.Width < options.MinRoomSize+2 || root.area.Height < options.MinRoomSize+2 {
+		// The map is too small to hold a single room
+		surface.FloorTiles = nil
+		return &dungeon
+	}
+
+	splitLeaf(root, options.MinLeafSize, rng)
+
+	// Step 3: Place a room in each of the areas the map was split into
+	placeRooms(root, options, &dungeon, rng)
+
+	for _, room := range dungeon.Rooms {
+		for x := room.X; x < room.X+room.Width; x++ {
+			for y := room.Y; y < room.Y+room.Height; y++ {
+				setFloor(surface, x, y, floorGlyph)
+			}
+		}
+	}
+
+	// Step 4: Connect the rooms on either side of each split
+	connectLeaves(root, surface, floorGlyph, options.CorridorStyle, &dungeon, rng)
+
+	dungeon.Doors = findDoors(surface, dungeon.Rooms)
+	surface.FloorTiles = collectFloorTiles(surface)
+
+	return &dungeon
+}
+
+// splitLeaf splits a leaf in two, and then splits each half, until the leaves are too small to split. Long, thin leaves
+// are split across their length, so the leaves stay roughly square.
+func splitLeaf(leaf *bspLeaf, minLeafSize int, rng *randomnumbergenerator.RNG) {
+	canSplitWidth := leaf.area.Width >= minLeafSize*2
+	canSplitHeight := leaf.area.Height >= minLeafSize*2
+
+	if !canSplitWidth && !canSplitHeight {
+		return
+	}
+
+	splitVertically := canSplitWidth
+	if canSplitWidth && canSplitHeight {
+		if leaf.area.Width*4 >= leaf.area.Height*5 {
+			splitVertically = true
+		} else if leaf.area.Height*4 >= leaf.area.Width*5 {
+			splitVertically = false
+		} else {
+			splitVertically = rng.Range(0, 2) == 0
+		}
+	}
+
+	area := leaf.area
+	if splitVertically {
+		split := minLeafSize + rng.Range(0, area.Width-minLeafSize*2+1)
+		leaf.left = &bspLeaf{area: Rect{X: area.X, Y: area.Y, Width: split, Height: area.Height}}
+		leaf.right = &bspLeaf{area: Rect{X: area.X + split, Y: area.Y, Width: area.Width - split, Height: area.Height}}
+	} else {
+		split := minLeafSize + rng.Range(0, area.Height-minLeafSize*2+1)
+		leaf.left = &bspLeaf{area: Rect{X: area.X, Y: area.Y, Width: area.Width, Height: split}}
+		leaf.right = &bspLeaf{area: Rect{X: area.X, Y: area.Y + split, Width: area.Width, Height: area.Height - split}}
+	}
+
+	splitLeaf(leaf.left, minLeafSize, rng)
+	splitLeaf(leaf.right, minLeafSize, rng)
+}
+
+// placeRooms places a room in every leaf that has not been split. Each room is kept at least one tile away from the
+// edge of its leaf, so that rooms in neighboring leaves never touch.
+func placeRooms(leaf *bspLeaf, options DungeonOptions, dungeon *Dungeon, rng *randomnumbergenerator.RNG) {
+	if leaf.left != nil {
+		placeRooms(leaf.left, options, dungeon, rng)
+		placeRooms(leaf.right, options, dungeon, rng)
+		return
+	}
+
+	width := randomRoomSize(options.MinRoomSize, options.MaxRoomSize, leaf.area.Width-2, rng)
+	height := randomRoomSize(options.MinRoomSize, options.MaxRoomSize, leaf.area.Height-2, rng)
+
+	room := Rect{
+		X:      leaf.area.X + 1 + rng.Range(0, leaf.area.Width-2-width+1),
+		Y:      leaf.area.Y + 1 + rng.Range(0, leaf.area.Height-2-height+1),
+		Width:  width,
+		Height: height,
+	}
+
+	leaf.room = &room
+	dungeon.Rooms = append(dungeon.Rooms, room)
+}
+
+// randomRoomSize returns a random room size between minSize and maxSize, that is no larger than the space available
+func randomRoomSize(minSize, maxSize, space int, rng *randomnumbergenerator.RNG) int {
+	if maxSize > space {
+		maxSize = space
+	}
+
+	return minSize + rng.Range(0, maxSize-minSize+1)
+}
+
+// connectLeaves joins the two halves of every split with a corridor, starting from the bottom of the tree, so that every
+// room is reachable from every other room
+func connectLeaves(leaf *bspLeaf, surface *gamemap.GameMap, floorGlyph ui.Glyph, style CorridorStyle, dungeon *Dungeon, rng *randomnumbergenerator.RNG) {
+	if leaf.left == nil {
+		return
+	}
+
+	connectLeaves(leaf.left, surface, floorGlyph, style, dungeon, rng)
+	connectLeaves(leaf.right, surface, floorGlyph, style, dungeon, rng)
+
+	// Join the closest pair of rooms on either side of the split, which keeps corridors short, and stops them from
+	// cutting across other rooms more than they need to
+	var from, to Rect
+	closest := -1
+	for _, leftRoom := range leafRooms(leaf.left) {
+		for _, rightRoom := range leafRooms(leaf.right) {
+			leftX, leftY := leftRoom.Center()
+			rightX, rightY := rightRoom.Center()
+
+			distance := abs(leftX-rightX) + abs(leftY-rightY)
+			if closest < 0 || distance < closest {
+				closest = distance
+				from, to = leftRoom, rightRoom
+			}
+		}
+	}
+
+	fromX, fromY := from.Center()
+	toX, toY := to.Center()
+
+	corridor := corridorPath(fromX, fromY, toX, toY, style, rng)
+	for _, point := range corridor {
+		setFloor(surface, point.X, point.Y, floorGlyph)
+	}
+
+	dungeon.Corridors = append(dungeon.Corridors, corridor)
+}
+
+// leafRooms returns every room in a leaf, and the leaves it was split into
+func leafRooms(leaf *bspLeaf) []Rect {
+	if leaf.room != nil {
+		return []Rect{*leaf.room}
+	}
+
+	return append(leafRooms(leaf.left), leafRooms(leaf.right)...)
+}
+
+// corridorPath returns the tiles of a corridor between two points, in order. Every tile is next to the one before it
+// (never diagonally), and the corridor never leaves the rectangle the two points are the corners of.
+func corridorPath(fromX, fromY, toX, toY int, style CorridorStyle, rng *randomnumbergenerator.RNG) []gamemap.CoordinatePair {
+	path := []gamemap.CoordinatePair{{X: fromX, Y: fromY}}
+	x, y := fromX, fromY
+
+	switch style {
+	case WindingCorridors:
+		// Step along either axis at random, favoring the axis with further to go, so the corridor wanders, but still
+		// heads towards its destination
+		for x != toX || y != toY {
+			remainingX := abs(toX - x)
+			remainingY := abs(toY - y)
+
+			if rng.Range(0, remainingX+remainingY) < remainingX {
+				x += sign(toX - x)
+			} else {
+				y += sign(toY - y)
+			}
+
+			path = append(path, gamemap.CoordinatePair{X: x, Y: y})
+		}
+	default:
+		horizontalFirst := rng.Range(0, 2) == 0
+
+		for x != toX || y != toY {
+			if (horizontalFirst && x != toX) || y == toY {
+				x += sign(toX - x)
+			} else {
+				y += sign(toY - y)
+			}
+
+			path = append(path, gamemap.CoordinatePair{X: x, Y: y})
+		}
+	}
+
+	return path
+}
+
+// findDoors returns the tiles where a corridor passes through the wall of a room: floor tiles just outside a room, with
+// walls either side of them along the wall of the room. Each tile is only returned once, in the order the rooms are
+// given.
+func findDoors(surface *gamemap.GameMap, rooms []Rect) []gamemap.CoordinatePair {
+	doors := []gamemap.CoordinatePair{}
+	found := make(map[gamemap.CoordinatePair]bool)
+
+	insideRoom := func(x, y int) bool {
+		for _, room := range rooms {
+			if room.Contains(x, y) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	isDoor := func(x, y int, horizontalWall bool) bool {
+		if x <= 0 || y <= 0 || x >= surface.Width-1 || y >= surface.Height-1 {
+			return false
+		}
+
+		if surface.Tiles[x][y].IsWall() || insideRoom(x, y) {
+			return false
+		}
+
+		if horizontalWall {
+			return surface.Tiles[x-1][y].IsWall() && surface.Tiles[x+1][y].IsWall()
+		}
+
+		return surface.Tiles[x][y-1].IsWall() && surface.Tiles[x][y+1].IsWall()
+	}
+
+	addDoor := func(x, y int) {
+		door := gamemap.CoordinatePair{X: x, Y: y}
+		if !found[door] {
+			found[door] = true
+			doors = append(doors, door)
+		}
+	}
+
+	for _, room := range rooms {
+		for x := room.X; x < room.X+room.Width; x++ {
+			if isDoor(x, room.Y-1, true) {
+				addDoor(x, room.Y-1)
+			}
+
+			if isDoor(x, room.Y+room.Height, true) {
+				addDoor(x, room.Y+room.Height)
+			}
+		}
+
+		for y := room.Y; y < room.Y+room.Height; y++ {
+			if isDoor(room.X-1, y, false) {
+				addDoor(room.X-1, y)
+			}
+
+			if isDoor(room.X+room.Width, y, false) {
+				addDoor(room.X+room.Width, y)
+			}
+		}
+	}
+
+	return doors
+}
+
+// setFloor turns the tile at x, y into a floor tile
+func setFloor(surface *gamemap.GameMap, x, y int, floorGlyph ui.Glyph) {
+	surface.Tiles[x][y].Blocked = false
+	surface.Tiles[x][y].BlocksSight = false
+	surface.Tiles[x][y].Glyph = floorGlyph
+}
+
+// collectFloorTiles returns every tile on the map that is not a wall, column by column
+func collectFloorTiles(surface *gamemap.GameMap) []*gamemap.Tile {
+	floorTiles := []*gamemap.Tile{}
+
+	for x := 0; x < surface.Width; x++ {
+		for y := 0; y < surface.Height; y++ {
+			if !surface.Tiles[x][y].IsWall() {
+				floorTiles = append(floorTiles, surface.Tiles[x][y])
+			}
+		}
+	}
+
+	return floorTiles
+}
+
+// surfaceRNG returns the RNG used to generate a map, creating one seeded with the current time if the map does not have
+// one yet
+func surfaceRNG(surface *gamemap.GameMap) *randomnumbergenerator.RNG {
+	if surface.RNG == nil {
+		surface.RNG = randomnumbergenerator.NewRNG()
+	}
+
+	return surface.RNG
+}
+
+// abs returns the absolute value of n
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+
+	return n
+}
+
+// sign returns -1 if n is negative, 1 if n is positive, and 0 if n is zero
+func sign(n int) int {
+	if n < 0 {
+		return -1
+	}
+
+	if n > 0 {
+		return 1
+	}
+
+	return 0
+}
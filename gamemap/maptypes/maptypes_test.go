@@ -2,8 +2,10 @@ package maptypes
 
 import (
 	"github.com/gogue-framework/gogue/gamemap"
+	"github.com/gogue-framework/gogue/randomnumbergenerator"
 	"github.com/gogue-framework/gogue/ui"
 	"github.com/stretchr/testify/assert"
+	"strings"
 	"testing"
 )
 
@@ -56,3 +58,358 @@ func TestGenerateCavern(t *testing.T) {
 		assert.Equal(t, gameMap.Tiles[gameMap.Width-1][y].Glyph, wallGlyph)
 	}
 }
+
+// floodFill returns the number of floor tiles that can be reached from the given tile, moving up, down, left, or right
+func floodFill(gameMap *gamemap.GameMap, x, y int) int {
+	visited := make(map[*gamemap.Tile]bool)
+	stack := []*gamemap.Tile{gameMap.Tiles[x][y]}
+
+	for len(stack) > 0 {
+		tile := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		if visited[tile] || tile.IsWall() {
+			continue
+		}
+		visited[tile] = true
+
+		for _, offset := range [][2]int{{-1, 0}, {1, 0}, {0, -1}, {0, 1}} {
+			nx, ny := tile.X+offset[0], tile.Y+offset[1]
+			if nx >= 0 && ny >= 0 && nx < gameMap.Width && ny < gameMap.Height {
+				stack = append(stack, gameMap.Tiles[nx][ny])
+			}
+		}
+	}
+
+	return len(visited)
+}
+
+func TestRect(t *testing.T) {
+	room := Rect{X: 2, Y: 3, Width: 4, Height: 2}
+
+	x, y := room.Center()
+	assert.Equal(t, 4, x)
+	assert.Equal(t, 4, y)
+
+	assert.True(t, room.Contains(2, 3))
+	assert.True(t, room.Contains(5, 4))
+	assert.False(t, room.Contains(6, 4))
+	assert.False(t, room.Contains(5, 5))
+
+	assert.True(t, room.Intersects(Rect{X: 5, Y: 4, Width: 3, Height: 3}))
+	assert.False(t, room.Intersects(Rect{X: 6, Y: 3, Width: 3, Height: 3}))
+	assert.False(t, room.Intersects(Rect{X: 2, Y: 5, Width: 4, Height: 2}))
+}
+
+func TestGenerateDungeon(t *testing.T) {
+	wallGlyph = ui.NewGlyph("#", "white", "gray")
+	floorGlyph = ui.NewGlyph(".", "white", "gray")
+
+	for _, style := range []CorridorStyle{LShapedCorridors, WindingCorridors} {
+		gameMap := &gamemap.GameMap{Width: 80, Height: 50}
+		gameMap.InitializeMap()
+
+		options := DefaultDungeonOptions()
+		options.CorridorStyle = style
+		dungeon := GenerateDungeon(gameMap, wallGlyph, floorGlyph, options)
+
+		assert.Greater(t, len(dungeon.Rooms), 1)
+		assert.Equal(t, len(dungeon.Rooms)-1, len(dungeon.Corridors))
+		assert.NotEmpty(t, dungeon.Doors)
+
+		// Every tile is created, and the edges of the map are always walls
+		for x := 0; x <= gameMap.Width; x++ {
+			for y := 0; y <= gameMap.Height; y++ {
+				assert.NotNil(t, gameMap.Tiles[x][y])
+				if x == 0 || y == 0 || x >= gameMap.Width-1 || y >= gameMap.Height-1 {
+					assert.True(t, gameMap.Tiles[x][y].IsWall())
+				}
+			}
+		}
+
+		for i, room := range dungeon.Rooms {
+			assert.GreaterOrEqual(t, room.Width, options.MinRoomSize)
+			assert.LessOrEqual(t, room.Width, options.MaxRoomSize)
+			assert.GreaterOrEqual(t, room.Height, options.MinRoomSize)
+			assert.LessOrEqual(t, room.Height, options.MaxRoomSize)
+
+			for _, other := range dungeon.Rooms[i+1:] {
+				assert.False(t, room.Intersects(other))
+			}
+
+			for x := room.X; x < room.X+room.Width; x++ {
+				for y := room.Y; y < room.Y+room.Height; y++ {
+					assert.False(t, gameMap.Tiles[x][y].IsWall())
+				}
+			}
+		}
+
+		for _, door := range dungeon.Doors {
+			assert.False(t, gameMap.Tiles[door.X][door.Y].IsWall())
+		}
+
+		// Every floor tile is recorded, and every room can be reached from every other room
+		floorCount := 0
+		for x := 0; x < gameMap.Width; x++ {
+			for y := 0; y < gameMap.Height; y++ {
+				if !gameMap.Tiles[x][y].IsWall() {
+					floorCount++
+				}
+			}
+		}
+
+		assert.Equal(t, floorCount, len(gameMap.FloorTiles))
+
+		x, y := dungeon.Rooms[0].Center()
+		assert.Equal(t, floorCount, floodFill(gameMap, x, y))
+	}
+}
+
+func TestGenerateDungeonTooSmall(t *testing.T) {
+	gameMap := &gamemap.GameMap{Width: 5, Height: 5}
+	gameMap.InitializeMap()
+
+	dungeon := GenerateDungeon(gameMap, wallGlyph, floorGlyph, DefaultDungeonOptions())
+
+	assert.Empty(t, dungeon.Rooms)
+	assert.Empty(t, gameMap.FloorTiles)
+	assert.True(t, gameMap.Tiles[2][2].IsWall())
+}
+
+func TestGenerateCavernWithOptions(t *testing.T) {
+	wallGlyph = ui.NewGlyph("#", "white", "gray")
+	floorGlyph = ui.NewGlyph(".", "white", "gray")
+
+	for i := 0; i < 10; i++ {
+		gameMap := &gamemap.GameMap{Width: 60, Height: 40}
+		gameMap.InitializeMap()
+
+		options := DefaultCavernOptions()
+		assert.True(t, GenerateCavernWithOptions(gameMap, wallGlyph, floorGlyph, options))
+
+		// Every floor tile on the map is part of one connected cavern, and is recorded in FloorTiles
+		floorCount := 0
+		for x := 0; x < gameMap.Width; x++ {
+			for y := 0; y < gameMap.Height; y++ {
+				if x == 0 || y == 0 || x == gameMap.Width-1 || y == gameMap.Height-1 {
+					assert.True(t, gameMap.Tiles[x][y].IsWall())
+				}
+
+				if !gameMap.Tiles[x][y].IsWall() {
+					floorCount++
+				}
+			}
+		}
+
+		assert.Equal(t, floorCount, len(gameMap.FloorTiles))
+		assert.Equal(t, floorCount, floodFill(gameMap, gameMap.FloorTiles[0].X, gameMap.FloorTiles[0].Y))
+		assert.GreaterOrEqual(t, floorCount*100, options.MinFloorPercentage*(gameMap.Width-2)*(gameMap.Height-2))
+	}
+
+	// An impossible minimum is reported, after trying as many times as allowed
+	gameMap := &gamemap.GameMap{Width: 30, Height: 30}
+	gameMap.InitializeMap()
+	assert.False(t, GenerateCavernWithOptions(gameMap, wallGlyph, floorGlyph, CavernOptions{SmoothingPasses: 5, MinFloorPercentage: 101, MaxAttempts: 2}))
+	assert.Greater(t, len(gameMap.FloorTiles), 0)
+}
+
+func TestConnectCaverns(t *testing.T) {
+	wallGlyph = ui.NewGlyph("#", "white", "gray")
+	floorGlyph = ui.NewGlyph(".", "white", "gray")
+
+	// Three separate caverns, in a map of walls
+	gameMap := &gamemap.GameMap{Width: 20, Height: 10}
+	gameMap.InitializeMap()
+	for x := 0; x < gameMap.Width; x++ {
+		for y := 0; y < gameMap.Height; y++ {
+			gameMap.Tiles[x][y] = &gamemap.Tile{Glyph: wallGlyph, Blocked: true, BlocksSight: true, X: x, Y: y}
+		}
+	}
+
+	for _, room := range []Rect{{X: 1, Y: 1, Width: 3, Height: 3}, {X: 10, Y: 2, Width: 2, Height: 2}, {X: 15, Y: 6, Width: 4, Height: 3}} {
+		for x := room.X; x < room.X+room.Width; x++ {
+			for y := room.Y; y < room.Y+room.Height; y++ {
+				setFloor(gameMap, x, y, floorGlyph)
+			}
+		}
+	}
+
+	caverns := findCaverns(gameMap)
+	assert.Equal(t, 3, len(caverns))
+	assert.Equal(t, 9, len(caverns[0]))
+
+	connectCaverns(gameMap, caverns, floorGlyph, randomnumbergenerator.NewSeededRNG(1))
+
+	caverns = findCaverns(gameMap)
+	assert.Equal(t, 1, len(caverns))
+
+	// The edges of the map are left alone
+	for x := 0; x < gameMap.Width; x++ {
+		assert.True(t, gameMap.Tiles[x][gameMap.Height-1].IsWall())
+	}
+}
+
+// renderLayout draws a map as text, one row per line, with walls as # and floors as .
+func renderLayout(gameMap *gamemap.GameMap) string {
+	rows := []string{}
+
+	for y := 0; y < gameMap.Height; y++ {
+		row := ""
+		for x := 0; x < gameMap.Width; x++ {
+			if gameMap.Tiles[x][y].IsWall() {
+				row += "#"
+			} else {
+				row += "."
+			}
+		}
+		rows = append(rows, row)
+	}
+
+	return strings.Join(rows, "\n")
+}
+
+// seededMap creates a map that will be generated from the given seed
+func seededMap(width, height int, seed int64) *gamemap.GameMap {
+	gameMap := &gamemap.GameMap{Width: width, Height: height, RNG: randomnumbergenerator.NewSeededRNG(seed)}
+	gameMap.InitializeMap()
+
+	return gameMap
+}
+
+// Layouts generated from seed 1. If a change to a generator alters these, every level generated from a saved seed will
+// change too, so that should only happen on purpose.
+const goldenDungeon = `########################################
+########################################
+########################################
+########################################
+################################.....###
+###........#####################.....###
+###........############....#####.....###
+###........############....#####.....###
+###........############............#####
+###........................#############
+###........############....#############
+###........#############################
+###........#############################
+########################################
+########################################
+########################################`
+
+const goldenCavern = `########################################
+########################################
+########################################
+###############..#######################
+############......######################
+###########............#################
+#########...............################
+########...................#############
+########....................############
+########....................############
+#######......................###########
+######.......................###########
+#####........................###########
+#####.........................#####..###
+######..............###...............##
+########################################`
+
+func TestSeededGeneration(t *testing.T) {
+	wallGlyph = ui.NewGlyph("#", "white", "gray")
+	floorGlyph = ui.NewGlyph(".", "white", "gray")
+	dungeonOptions := DungeonOptions{MinRoomSize: 3, MaxRoomSize: 8, MinLeafSize: 8}
+
+	generators := map[string]func(gameMap *gamemap.GameMap){
+		"dungeon": func(gameMap *gamemap.GameMap) {
+			GenerateDungeon(gameMap, wallGlyph, floorGlyph, dungeonOptions)
+		},
+		"winding dungeon": func(gameMap *gamemap.GameMap) {
+			GenerateDungeon(gameMap, wallGlyph, floorGlyph, DungeonOptions{MinRoomSize: 3, MaxRoomSize: 8, CorridorStyle: WindingCorridors})
+		},
+		"cavern": func(gameMap *gamemap.GameMap) {
+			GenerateCavern(gameMap, wallGlyph, floorGlyph, 5)
+		},
+		"connected cavern": func(gameMap *gamemap.GameMap) {
+			GenerateCavernWithOptions(gameMap, wallGlyph, floorGlyph, DefaultCavernOptions())
+		},
+	}
+
+	// The same seed always produces the same map, and a different seed a different map
+	for name, generate := range generators {
+		first := seededMap(60, 30, 42)
+		generate(first)
+
+		second := seededMap(60, 30, 42)
+		generate(second)
+
+		other := seededMap(60, 30, 43)
+		generate(other)
+
+		assert.Equal(t, renderLayout(first), renderLayout(second), name)
+		assert.NotEqual(t, renderLayout(first), renderLayout(other), name)
+		assert.Equal(t, len(first.FloorTiles), len(second.FloorTiles), name)
+	}
+
+	gameMap := seededMap(40, 16, 1)
+	GenerateDungeon(gameMap, wallGlyph, floorGlyph, dungeonOptions)
+	assert.Equal(t, goldenDungeon, renderLayout(gameMap))
+
+	gameMap = seededMap(40, 16, 1)
+	GenerateCavernWithOptions(gameMap, wallGlyph, floorGlyph, DefaultCavernOptions())
+	assert.Equal(t, goldenCavern, renderLayout(gameMap))
+}
+
+func TestGenerateDrunkardsWalk(t *testing.T) {
+	wallGlyph = ui.NewGlyph("#", "white", "gray")
+	floorGlyph = ui.NewGlyph(".", "white", "gray")
+
+	optionSets := []DrunkardsWalkOptions{
+		DefaultDrunkardsWalkOptions(),
+		{Walkers: 5, WalkerLifetime: 50, TurnChance: 20, EdgeMargin: 3, TargetFloorPercentage: 50},
+		{Walkers: 2, TurnChance: 100, EdgeMargin: 2, StartX: 5, StartY: 5, TargetFloorPercentage: 30},
+	}
+
+	for _, options := range optionSets {
+		gameMap := seededMap(60, 30, 7)
+		assert.True(t, GenerateDrunkardsWalk(gameMap, wallGlyph, floorGlyph, options))
+
+		margin := options.EdgeMargin
+		floorCount := 0
+		for x := 0; x <= gameMap.Width; x++ {
+			for y := 0; y <= gameMap.Height; y++ {
+				if !gameMap.Tiles[x][y].IsWall() {
+					floorCount++
+
+					// Walkers keep out of the margin around the edge of the map
+					assert.True(t, x >= margin && y >= margin && x < gameMap.Width-margin && y < gameMap.Height-margin)
+				}
+			}
+		}
+
+		// The cave is connected, covers the target area, and every floor tile is recorded
+		assert.Equal(t, floorCount, len(gameMap.FloorTiles))
+		assert.GreaterOrEqual(t, floorCount*100, options.TargetFloorPercentage*(gameMap.Width-2)*(gameMap.Height-2)-100)
+		assert.Equal(t, floorCount, floodFill(gameMap, gameMap.FloorTiles[0].X, gameMap.FloorTiles[0].Y))
+
+		if options.StartX != 0 {
+			assert.False(t, gameMap.Tiles[options.StartX][options.StartY].IsWall())
+		} else {
+			assert.False(t, gameMap.Tiles[gameMap.Width/2][gameMap.Height/2].IsWall())
+		}
+	}
+
+	// The same seed always carves the same cave
+	first := seededMap(60, 30, 42)
+	GenerateDrunkardsWalk(first, wallGlyph, floorGlyph, DefaultDrunkardsWalkOptions())
+	second := seededMap(60, 30, 42)
+	GenerateDrunkardsWalk(second, wallGlyph, floorGlyph, DefaultDrunkardsWalkOptions())
+	assert.Equal(t, renderLayout(first), renderLayout(second))
+
+	// A walker that never turns can only carve a straight line, so cannot reach the target
+	gameMap := seededMap(30, 20, 1)
+	assert.False(t, GenerateDrunkardsWalk(gameMap, wallGlyph, floorGlyph, DrunkardsWalkOptions{Walkers: 1, TurnChance: 0, TargetFloorPercentage: 50}))
+	assert.Greater(t, len(gameMap.FloorTiles), 0)
+
+	// More floor than fits inside the margin cannot be carved either
+	gameMap = seededMap(20, 20, 1)
+	assert.False(t, GenerateDrunkardsWalk(gameMap, wallGlyph, floorGlyph, DrunkardsWalkOptions{TurnChance: 100, EdgeMargin: 5, TargetFloorPercentage: 90}))
+	assert.Equal(t, 10*10, len(gameMap.FloorTiles))
+}
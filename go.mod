@@ -1,8 +1,15 @@
 module github.com/gogue-framework/gogue
 
-go 1.12
+go 1.18
 
 require (
+	github.com/BurntSushi/toml v1.2.1
 	github.com/gogue-framework/bearlibterminalgo v1.0.1
 	github.com/stretchr/testify v1.5.1
+	gopkg.in/yaml.v2 v2.4.0
+)
+
+require (
+	github.com/davecgh/go-spew v1.1.0 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
 )
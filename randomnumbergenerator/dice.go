@@ -1,7 +1,10 @@
 package randomnumbergenerator
 
 import (
+	"fmt"
 	"math/rand"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -50,3 +53,109 @@ func (diceRoller *DiceRoller) RollNSidedDieOpenEnded(n int) int {
 
 	return totalRoll
 }
+
+// RollDice rolls the dice described by a Dice expression
+func (diceRoller *DiceRoller) RollDice(dice Dice) int {
+	return dice.Roll(&diceRoller.rng)
+}
+
+// Dice describes a roll of one or more dice, in the usual "NdS+M" notation: Count dice with Sides sides each are
+// rolled, and added together, along with Modifier. A Dice with no sides always rolls Modifier, which allows fixed
+// values to be used anywhere dice are.
+type Dice struct {
+	Count    int
+	Sides    int
+	Modifier int
+}
+
+// ParseDice parses a dice expression, such as "2d6", "1d4+1", "3d8-2", or "d20" (a single die). A plain number, such
+// as "3", is a fixed value.
+func ParseDice(expression string) (Dice, error) {
+	dice := Dice{}
+	text := strings.ToLower(strings.ReplaceAll(expression, " ", ""))
+
+	if text == "" {
+		return dice, fmt.Errorf("invalid dice expression %q", expression)
+	}
+
+	index := strings.Index(text, "d")
+	if index < 0 {
+		modifier, err := strconv.Atoi(text)
+		if err != nil {
+			return dice, fmt.Errorf("invalid dice expression %q", expression)
+		}
+
+		dice.Modifier = modifier
+		return dice, nil
+	}
+
+	dice.Count = 1
+	if index > 0 {
+		count, err := strconv.Atoi(text[:index])
+		if err != nil || count < 0 {
+			return dice, fmt.Errorf("invalid dice count in %q", expression)
+		}
+		dice.Count = count
+	}
+
+	sides := text[index+1:]
+	if modifierIndex := strings.IndexAny(sides, "+-"); modifierIndex >= 0 {
+		modifier, err := strconv.Atoi(sides[modifierIndex:])
+		if err != nil {
+			return dice, fmt.Errorf("invalid dice modifier in %q", expression)
+		}
+		dice.Modifier = modifier
+		sides = sides[:modifierIndex]
+	}
+
+	sideCount, err := strconv.Atoi(sides)
+	if err != nil || sideCount < 1 {
+		return dice, fmt.Errorf("invalid number of sides in %q", expression)
+	}
+	dice.Sides = sideCount
+
+	return dice, nil
+}
+
+// Roll rolls the dice using the given RNG, and returns the total
+func (dice Dice) Roll(rng *RNG) int {
+	total := dice.Modifier
+
+	if dice.Sides > 0 {
+		for i := 0; i < dice.Count; i++ {
+			total += rng.Range(0, dice.Sides) + 1
+		}
+	}
+
+	return total
+}
+
+// Min returns the lowest value the dice can roll
+func (dice Dice) Min() int {
+	if dice.Sides == 0 {
+		return dice.Modifier
+	}
+
+	return dice.Count + dice.Modifier
+}
+
+// Max returns the highest value the dice can roll
+func (dice Dice) Max() int {
+	return dice.Count*dice.Sides + dice.Modifier
+}
+
+// String returns the dice in "NdS+M" notation
+func (dice Dice) String() string {
+	if dice.Sides == 0 {
+		return strconv.Itoa(dice.Modifier)
+	}
+
+	text := fmt.Sprintf("%vd%v", dice.Count, dice.Sides)
+	if dice.Modifier > 0 {
+		text += fmt.Sprintf("+%v", dice.Modifier)
+	} else if dice.Modifier < 0 {
+		text += strconv.Itoa(dice.Modifier)
+	}
+
+	return text
+}
@@ -24,6 +24,16 @@ func NewRNG() *RNG {
 	return &rng
 }
 
+// NewSeededRNG creates a new RNG with the given seed. Two RNGs created with the same seed produce the same sequence of
+// values, which makes it possible to reproduce anything generated with them.
+func NewSeededRNG(seed int64) *RNG {
+	rng := RNG{}
+	rng.seed = seed
+	rng.rand = rand.New(rand.NewSource(seed))
+
+	return &rng
+}
+
 // GetSeed returns the seed value for the RNG
 func (rng *RNG) GetSeed() int64 {
 	return rng.seed
@@ -105,3 +115,35 @@ func (rng *RNG) GetWeightedEntity(values map[int]int) int {
 
 	return -1
 }
+
+// WeightedIndex takes a list of weights, and returns the index of one of them, chosen at random. An index with a higher
+// weight is more likely to be chosen than one with a lower weight, and an index with a weight of zero (or less) is never
+// chosen. Unlike GetWeightedEntity, the choice only depends on the order of the weights, so the same seed always
+// produces the same choices. If no weight is above zero, -1 is returned.
+func (rng *RNG) WeightedIndex(weights []int) int {
+	totalWeight := 0
+	for _, weight := range weights {
+		if weight > 0 {
+			totalWeight += weight
+		}
+	}
+
+	if totalWeight == 0 {
+		return -1
+	}
+
+	r := rng.rand.Intn(totalWeight)
+
+	for i, weight := range weights {
+		if weight <= 0 {
+			continue
+		}
+
+		r -= weight
+		if r < 0 {
+			return i
+		}
+	}
+
+	return -1
+}
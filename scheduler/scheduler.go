@@ -0,0 +1,246 @@
+package scheduler
+
+import (
+	"reflect"
+	"sort"
+
+	"github.com/gogue-framework/gogue/ecs"
+)
+
+const (
+	// DefaultEnergyThreshold is the amount of energy an actor needs before it is allowed to act
+	DefaultEnergyThreshold = 100
+	// NormalSpeed is the speed of an average actor. An actor with NormalSpeed gains enough energy to act every ten
+	// ticks, an actor with double that speed every five.
+	NormalSpeed = 10
+	// NormalActionCost is the energy cost of an average action, such as moving one tile or attacking
+	NormalActionCost = 100
+	// DefaultMaxTicks is the maximum number of ticks a single call to Process will advance time by, if no actor is
+	// waiting for input
+	DefaultMaxTicks = 100
+)
+
+// ActorComponent marks an entity as an actor, that takes turns. Every tick, each actor gains Speed energy. Once an
+// actor has at least the schedulers energy threshold, it may act, and the energy cost of its action is deducted from
+// its Energy. Faster actors therefore act more often than slower ones.
+type ActorComponent struct {
+	Speed  int
+	Energy int
+}
+
+// TypeOf returns the reflect.Type of the ActorComponent
+func (ac ActorComponent) TypeOf() reflect.Type { return reflect.TypeOf(ac) }
+
+// ActionProvider decides what an actor does when its turn comes up. Act performs the action for the given entity, and
+// returns the energy cost of the action. Every action must cost something, otherwise the actor would keep its turn
+// forever, so a cost of zero or less is treated as NormalActionCost. If the actor is not ready to act yet (for example,
+// the player has not pressed a key), Act should return false, and the scheduler will pause until it is asked to
+// continue, at which point the same actor is asked again.
+type ActionProvider interface {
+	Act(entity int) (cost int, ok bool)
+}
+
+// ActionFunc allows a plain function to be used as an ActionProvider
+type ActionFunc func(entity int) (cost int, ok bool)
+
+// Act calls the function itself
+func (f ActionFunc) Act(entity int) (int, bool) {
+	return f(entity)
+}
+
+// Scheduler is an energy based turn scheduler. It keeps track of time for every entity with an ActorComponent, and
+// decides whose turn it is. Entities act in order of how much energy they have (most first), and then by entity ID.
+// The Scheduler is an ecs.System, so it can be added to a Controller and processed along with every other system. Each
+// call to Process runs turns until an actor is waiting for input, or DefaultMaxTicks ticks have passed (see
+// SetMaxTicks).
+type Scheduler struct {
+	controller      *ecs.Controller
+	providers       map[int]ActionProvider
+	defaultProvider ActionProvider
+	threshold       int
+	maxTicks        int
+	tick            int
+	waiting         int
+	isWaiting       bool
+}
+
+// NewScheduler creates a new Scheduler for the actors on a Controller
+func NewScheduler(controller *ecs.Controller) *Scheduler {
+	s := Scheduler{}
+	s.controller = controller
+	s.providers = make(map[int]ActionProvider)
+	s.threshold = DefaultEnergyThreshold
+	s.maxTicks = DefaultMaxTicks
+
+	// Forget about actors as soon as they stop being actors, so that a recycled entity ID does not inherit them
+	controller.OnRemove(ecs.ComponentType[ActorComponent](), func(entity int, component ecs.Component) {
+		delete(s.providers, entity)
+
+		if s.isWaiting && s.waiting == entity {
+			s.isWaiting = false
+		}
+	})
+
+	return &s
+}
+
+// SetActionProvider sets the ActionProvider that decides what an actor entity does on its turn
+func (s *Scheduler) SetActionProvider(entity int, provider ActionProvider) {
+	s.providers[entity] = provider
+}
+
+// RemoveActionProvider removes the ActionProvider for an actor entity, so it falls back to the default provider
+func (s *Scheduler) RemoveActionProvider(entity int) {
+	delete(s.providers, entity)
+}
+
+// SetDefaultActionProvider sets the ActionProvider used for actors that do not have one of their own. Without a
+// default provider, those actors simply wait, spending NormalActionCost energy each turn.
+func (s *Scheduler) SetDefaultActionProvider(provider ActionProvider) {
+	s.defaultProvider = provider
+}
+
+// SetEnergyThreshold sets the amount of energy an actor needs before it may act
+func (s *Scheduler) SetEnergyThreshold(threshold int) {
+	s.threshold = threshold
+}
+
+// SetMaxTicks sets the maximum number of ticks a single call to Process will advance time by. This keeps Process from
+// running forever in a world where no actor ever waits for input. With a maximum of 0, Process only lets actors that
+// already have enough energy act.
+func (s *Scheduler) SetMaxTicks(ticks int) {
+	if ticks < 0 {
+		ticks = 0
+	}
+
+	s.maxTicks = ticks
+}
+
+// Tick returns the number of ticks that have passed since the scheduler was created
+func (s *Scheduler) Tick() int {
+	return s.tick
+}
+
+// WaitingFor returns the actor the scheduler is waiting on for input, if any. This is usually the player, and can be
+// used to decide whether to accept input.
+func (s *Scheduler) WaitingFor() (int, bool) {
+	return s.waiting, s.isWaiting
+}
+
+// Process runs turns, until an actor is waiting for input, there are no actors left, or the maximum number of ticks
+// for a single call has passed. If an actor was already waiting for input, it is asked to act again first.
+func (s *Scheduler) Process() {
+	ticks := 0
+
+	for {
+		if s.isWaiting {
+			if !s.act(s.waiting) {
+				return
+			}
+			continue
+		}
+
+		actor, ok := s.nextActor()
+		if ok {
+			if !s.act(actor) {
+				return
+			}
+			continue
+		}
+
+		// Everyone who could act has acted, move time forward
+		if ticks >= s.maxTicks || !s.advance() {
+			return
+		}
+		ticks++
+	}
+}
+
+// act asks an actor to act, and deducts the cost of its action from its energy. It returns false if the actor is
+// waiting for input.
+func (s *Scheduler) act(entity int) bool {
+	actor, ok := ecs.Get[ActorComponent](s.controller, entity)
+	if !ok {
+		// The actor has been removed since it was asked last
+		s.isWaiting = false
+		return true
+	}
+
+	cost := NormalActionCost
+	provider := s.provider(entity)
+	if provider != nil {
+		cost, ok = provider.Act(entity)
+		if !ok {
+			s.waiting = entity
+			s.isWaiting = true
+			return false
+		}
+	}
+
+	s.isWaiting = false
+
+	// The action may have changed the actor (or removed it entirely), so fetch it again before spending its energy
+	actor, ok = ecs.Get[ActorComponent](s.controller, entity)
+	if ok {
+		if cost <= 0 {
+			cost = NormalActionCost
+		}
+
+		actor.Energy -= cost
+		ecs.Add(s.controller, entity, actor)
+	}
+
+	return true
+}
+
+// provider returns the ActionProvider for an actor, or the default provider if it does not have one
+func (s *Scheduler) provider(entity int) ActionProvider {
+	if provider, ok := s.providers[entity]; ok {
+		return provider
+	}
+
+	return s.defaultProvider
+}
+
+// nextActor returns the actor whose turn it is, if any actor has enough energy to act. The actor with the most energy
+// goes first, with ties going to the lowest entity ID.
+func (s *Scheduler) nextActor() (int, bool) {
+	ready := []int{}
+	energy := make(map[int]int)
+
+	ecs.Each(s.controller, func(entity int, actor ActorComponent) {
+		if actor.Energy >= s.threshold {
+			ready = append(ready, entity)
+			energy[entity] = actor.Energy
+		}
+	})
+
+	if len(ready) == 0 {
+		return 0, false
+	}
+
+	sort.SliceStable(ready, func(i, j int) bool {
+		return energy[ready[i]] > energy[ready[j]]
+	})
+
+	return ready[0], true
+}
+
+// advance moves time forward by one tick, giving every actor energy equal to its speed. It returns false if there are
+// no actors.
+func (s *Scheduler) advance() bool {
+	actors := 0
+
+	ecs.Each(s.controller, func(entity int, actor ActorComponent) {
+		actor.Energy += actor.Speed
+		ecs.Add(s.controller, entity, actor)
+		actors++
+	})
+
+	if actors == 0 {
+		return false
+	}
+
+	s.tick++
+	return true
+}
@@ -0,0 +1,160 @@
+package scheduler
+
+import (
+	"github.com/gogue-framework/gogue/ecs"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestSpeedDeterminesTurnOrder(t *testing.T) {
+	controller := ecs.NewController()
+	scheduler := NewScheduler(controller)
+	scheduler.SetMaxTicks(20)
+
+	slow := controller.CreateEntity([]ecs.Component{ActorComponent{Speed: NormalSpeed}})
+	fast := controller.CreateEntity([]ecs.Component{ActorComponent{Speed: NormalSpeed * 2}})
+
+	turns := []int{}
+	scheduler.SetDefaultActionProvider(ActionFunc(func(entity int) (int, bool) {
+		turns = append(turns, entity)
+		return NormalActionCost, true
+	}))
+
+	scheduler.Process()
+
+	// Over twenty ticks, the fast actor acts four times, and the slow actor twice
+	assert.Equal(t, []int{fast, slow, fast, fast, slow, fast}, turns)
+	assert.Equal(t, 20, scheduler.Tick())
+}
+
+func TestMostEnergyActsFirst(t *testing.T) {
+	controller := ecs.NewController()
+	scheduler := NewScheduler(controller)
+	scheduler.SetMaxTicks(0)
+
+	first := controller.CreateEntity([]ecs.Component{ActorComponent{Speed: NormalSpeed, Energy: 100}})
+	second := controller.CreateEntity([]ecs.Component{ActorComponent{Speed: NormalSpeed, Energy: 100}})
+	eager := controller.CreateEntity([]ecs.Component{ActorComponent{Speed: NormalSpeed, Energy: 150}})
+
+	turns := []int{}
+	scheduler.SetDefaultActionProvider(ActionFunc(func(entity int) (int, bool) {
+		turns = append(turns, entity)
+		return NormalActionCost, true
+	}))
+
+	scheduler.Process()
+
+	// The actor with the most energy goes first, ties go to the lowest entity ID
+	assert.Equal(t, []int{eager, first, second}, turns)
+}
+
+func TestPlayerTurnBlocksUntilInput(t *testing.T) {
+	controller := ecs.NewController()
+	scheduler := NewScheduler(controller)
+
+	monster := controller.CreateEntity([]ecs.Component{ActorComponent{Speed: NormalSpeed}})
+	player := controller.CreateEntity([]ecs.Component{ActorComponent{Speed: NormalSpeed, Energy: 100}})
+
+	input := false
+	playerTurns := 0
+	monsterTurns := 0
+
+	scheduler.SetActionProvider(player, ActionFunc(func(entity int) (int, bool) {
+		if !input {
+			return 0, false
+		}
+
+		input = false
+		playerTurns++
+		return NormalActionCost, true
+	}))
+	scheduler.SetActionProvider(monster, ActionFunc(func(entity int) (int, bool) {
+		monsterTurns++
+		return NormalActionCost, true
+	}))
+
+	// Without input, nothing happens, no matter how often the scheduler is processed
+	scheduler.Process()
+	scheduler.Process()
+	waiting, ok := scheduler.WaitingFor()
+	assert.True(t, ok)
+	assert.Equal(t, player, waiting)
+	assert.Equal(t, 0, playerTurns)
+	assert.Equal(t, 0, scheduler.Tick())
+
+	// Once input is available, the player acts, the monster gets a turn, and the scheduler waits for the player again
+	input = true
+	scheduler.Process()
+	assert.Equal(t, 1, playerTurns)
+	assert.Equal(t, 1, monsterTurns)
+	assert.Equal(t, 10, scheduler.Tick())
+	_, ok = scheduler.WaitingFor()
+	assert.True(t, ok)
+
+	actor, _ := ecs.Get[ActorComponent](controller, player)
+	assert.Equal(t, 100, actor.Energy)
+}
+
+func TestRemovedActorIsForgotten(t *testing.T) {
+	controller := ecs.NewController()
+	scheduler := NewScheduler(controller)
+
+	player := controller.CreateEntity([]ecs.Component{ActorComponent{Speed: NormalSpeed, Energy: 100}})
+	scheduler.SetActionProvider(player, ActionFunc(func(entity int) (int, bool) {
+		return 0, false
+	}))
+
+	scheduler.Process()
+	_, ok := scheduler.WaitingFor()
+	assert.True(t, ok)
+
+	controller.DeleteEntity(player)
+	_, ok = scheduler.WaitingFor()
+	assert.False(t, ok)
+
+	// With no actors left, processing does nothing
+	scheduler.Process()
+	assert.Equal(t, 0, scheduler.Tick())
+}
+
+func TestSchedulerAsSystem(t *testing.T) {
+	controller := ecs.NewController()
+	scheduler := NewScheduler(controller)
+	scheduler.SetMaxTicks(10)
+
+	actor := controller.CreateEntity([]ecs.Component{ActorComponent{Speed: NormalSpeed}})
+
+	assert.Nil(t, controller.AddNamedSystem("scheduler", scheduler, 0))
+	controller.ProcessNamedSystem("scheduler")
+
+	// Without an action provider, the actor waits, spending the energy of a normal action
+	component, _ := ecs.Get[ActorComponent](controller, actor)
+	assert.Equal(t, 0, component.Energy)
+	assert.Equal(t, 10, scheduler.Tick())
+}
+
+func TestFreeActionsCostNormalActionCost(t *testing.T) {
+	controller := ecs.NewController()
+	scheduler := NewScheduler(controller)
+	scheduler.SetMaxTicks(20)
+
+	free := controller.CreateEntity([]ecs.Component{ActorComponent{Speed: NormalSpeed, Energy: 100}})
+	refund := controller.CreateEntity([]ecs.Component{ActorComponent{Speed: NormalSpeed, Energy: 100}})
+
+	turns := []int{}
+	scheduler.SetActionProvider(free, ActionFunc(func(entity int) (int, bool) {
+		turns = append(turns, entity)
+		return 0, true
+	}))
+	scheduler.SetActionProvider(refund, ActionFunc(func(entity int) (int, bool) {
+		turns = append(turns, entity)
+		return -50, true
+	}))
+
+	// Without a cost, these actors would keep their turn forever, and Process would never return
+	scheduler.Process()
+
+	assert.Equal(t, []int{free, refund, free, refund, free, refund}, turns)
+	actor, _ := ecs.Get[ActorComponent](controller, free)
+	assert.Equal(t, 0, actor.Energy)
+}